@@ -0,0 +1,383 @@
+package flapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultAbbreviations are the abbreviations used by NewAbbreviator when it
+// isn't given any, keyed by the lowercase word or phrase they shorten.
+var DefaultAbbreviations = map[string]string{
+	// Street suffixes.
+	"street":     "st",
+	"avenue":     "ave",
+	"boulevard":  "blvd",
+	"road":       "rd",
+	"drive":      "dr",
+	"lane":       "ln",
+	"court":      "ct",
+	"place":      "pl",
+	"square":     "sq",
+	"terrace":    "ter",
+	"parkway":    "pkwy",
+	"highway":    "hwy",
+	"expressway": "expy",
+	"freeway":    "fwy",
+	"circle":     "cir",
+	"heights":    "hts",
+	"junction":   "jct",
+
+	// Places.
+	"mount":       "mt",
+	"mountain":    "mtn",
+	"mountains":   "mtns",
+	"fort":        "ft",
+	"saint":       "st",
+	"point":       "pt",
+	"island":      "is.",
+	"islands":     "is.",
+	"peninsula":   "pen.",
+	"archipelago": "arch.",
+	"region":      "reg.",
+	"river":       "riv.",
+	"lake":        "lk",
+	"valley":      "vly",
+	"harbor":      "hbr",
+	"district":    "dist.",
+	"county":      "co.",
+	"village":     "vlg",
+	"center":      "ctr",
+	"airport":     "arpt",
+	"station":     "sta.",
+
+	// Compass points.
+	"north":     "n",
+	"south":     "s",
+	"east":      "e",
+	"west":      "w",
+	"northeast": "ne",
+	"northwest": "nw",
+	"southeast": "se",
+	"southwest": "sw",
+	"northern":  "n.",
+	"southern":  "s.",
+	"eastern":   "e.",
+	"western":   "w.",
+	"central":   "c.",
+
+	// Months and weekdays.
+	"january":   "jan",
+	"february":  "feb",
+	"march":     "mar",
+	"april":     "apr",
+	"june":      "jun",
+	"july":      "jul",
+	"august":    "aug",
+	"september": "sep",
+	"october":   "oct",
+	"november":  "nov",
+	"december":  "dec",
+	"monday":    "mon",
+	"tuesday":   "tue",
+	"wednesday": "wed",
+	"thursday":  "thu",
+	"friday":    "fri",
+	"saturday":  "sat",
+	"sunday":    "sun",
+
+	// US states.
+	"alabama":              "al",
+	"alaska":               "ak",
+	"arizona":              "az",
+	"arkansas":             "ar",
+	"california":           "ca",
+	"colorado":             "co",
+	"connecticut":          "ct",
+	"delaware":             "de",
+	"district of columbia": "dc",
+	"florida":              "fl",
+	"georgia":              "ga",
+	"hawaii":               "hi",
+	"idaho":                "id",
+	"illinois":             "il",
+	"indiana":              "in",
+	"iowa":                 "ia",
+	"kansas":               "ks",
+	"kentucky":             "ky",
+	"louisiana":            "la",
+	"maine":                "me",
+	"maryland":             "md",
+	"massachusetts":        "ma",
+	"michigan":             "mi",
+	"minnesota":            "mn",
+	"mississippi":          "ms",
+	"missouri":             "mo",
+	"montana":              "mt",
+	"nebraska":             "ne",
+	"nevada":               "nv",
+	"new hampshire":        "nh",
+	"new jersey":           "nj",
+	"new mexico":           "nm",
+	"new york":             "ny",
+	"north carolina":       "nc",
+	"north dakota":         "nd",
+	"ohio":                 "oh",
+	"oklahoma":             "ok",
+	"oregon":               "or",
+	"pennsylvania":         "pa",
+	"rhode island":         "ri",
+	"south carolina":       "sc",
+	"south dakota":         "sd",
+	"tennessee":            "tn",
+	"texas":                "tx",
+	"utah":                 "ut",
+	"vermont":              "vt",
+	"virginia":             "va",
+	"washington":           "wa",
+	"west virginia":        "wv",
+	"wisconsin":            "wi",
+	"wyoming":              "wy",
+	"puerto rico":          "pr",
+
+	// Common words.
+	"international": "intl",
+	"national":      "natl",
+	"government":    "govt",
+	"department":    "dept",
+	"university":    "univ",
+	"approximately": "approx",
+	"temperature":   "temp",
+	"information":   "info",
+	"association":   "assn",
+	"corporation":   "corp",
+	"company":       "co.",
+	"incorporated":  "inc",
+	"limited":       "ltd",
+	"building":      "bldg",
+	"apartment":     "apt",
+	"hospital":      "hosp",
+	"minutes":       "min",
+	"hours":         "hrs",
+	"kilometers":    "km",
+	"kilometres":    "km",
+	"miles":         "mi",
+	"number":        "no.",
+}
+
+// Abbreviator shortens text to fit the space it has, using as few, and as
+// mild, abbreviations as it can.
+type Abbreviator struct {
+	words    map[string]string // Abbreviations by lowercase word or phrase.
+	wordRE   *regexp.Regexp    // Matches any of the words.
+	patterns []abbrevPattern
+}
+
+// abbrevPattern is text matched by a regular expression, and its
+// abbreviation, which can refer to submatches.
+type abbrevPattern struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// abbrevCandidate is one place the text could be abbreviated.
+type abbrevCandidate struct {
+	start, end int    // Where the text being replaced is, in bytes.
+	short      string // What it would be replaced with.
+	loss       float64
+	saves      int // The number of runes saved.
+}
+
+// NewAbbreviator returns an Abbreviator using words, keyed by the lowercase
+// word or phrase they shorten, or DefaultAbbreviations if words is nil.
+func NewAbbreviator(words map[string]string) *Abbreviator {
+	if words == nil {
+		words = DefaultAbbreviations
+	}
+	a := &Abbreviator{words: make(map[string]string)}
+	for long, short := range words {
+		a.words[phraseKey(long)] = short
+	}
+	a.compile()
+	return a
+}
+
+// phraseKey returns the key a word or phrase is looked up by: lowercase,
+// with single spaces between words.
+func phraseKey(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// LoadAbbreviations returns an Abbreviator using DefaultAbbreviations and the
+// ones in a JSON file, which maps the words or phrases to shorten to their
+// abbreviations. Entries in the file replace defaults for the same word, and
+// a word can be mapped to "" to drop it when the space is needed.
+func LoadAbbreviations(path string) (*Abbreviator, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var words map[string]string
+	err = json.Unmarshal(b, &words)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	all := make(map[string]string)
+	for long, short := range DefaultAbbreviations {
+		all[long] = short
+	}
+	for long, short := range words {
+		all[phraseKey(long)] = short
+	}
+	return NewAbbreviator(all), nil
+}
+
+// compile builds the expression matching any of the words, trying longer
+// ones first so phrases win over the words in them.
+func (a *Abbreviator) compile() {
+	var alts []string
+	for long := range a.words {
+		alts = append(alts, long)
+	}
+	sort.Slice(alts, func(i, j int) bool {
+		if len(alts[i]) != len(alts[j]) {
+			return len(alts[i]) > len(alts[j])
+		}
+		return alts[i] < alts[j]
+	})
+	for i, long := range alts {
+		alts[i] = strings.ReplaceAll(regexp.QuoteMeta(long), " ", `\s+`)
+	}
+	if len(alts) > 0 {
+		a.wordRE = regexp.MustCompile(`(?i)\b(?:` + strings.Join(alts, "|") + `)\b`)
+	}
+}
+
+// AddPattern adds an abbreviation for text matching re, which is replaced
+// as regexp.ReplaceAllString would replace it. Since patterns usually match
+// text that's left out altogether, they're often the last thing used.
+func (a *Abbreviator) AddPattern(re *regexp.Regexp, repl string) {
+	a.patterns = append(a.patterns, abbrevPattern{re: re, repl: repl})
+}
+
+// Shorten abbreviates text until fits reports that it fits, one word or
+// phrase at a time. The abbreviation that loses the smallest part of what it
+// replaces is used first, and of those, the one that saves the most; ties go
+// to the earliest in the text. If the text can't be made to fit, it's
+// returned with every abbreviation used.
+func (a *Abbreviator) Shorten(text string, fits func(string) bool) string {
+	for !fits(text) {
+		best, ok := a.best(text)
+		if !ok {
+			break
+		}
+		text = text[:best.start] + best.short + text[best.end:]
+	}
+	return text
+}
+
+// best returns the abbreviation to use next in text, if there is one.
+func (a *Abbreviator) best(text string) (abbrevCandidate, bool) {
+	var cands []abbrevCandidate
+	add := func(start, end int, short string) {
+		long := text[start:end]
+		n := utf8.RuneCountInString(long)
+		saves := n - utf8.RuneCountInString(short)
+		if saves <= 0 {
+			// Only abbreviations that make the text shorter are used, so
+			// Shorten always finishes.
+			return
+		}
+		cands = append(cands, abbrevCandidate{
+			start: start,
+			end:   end,
+			short: matchCase(long, short),
+			loss:  float64(saves) / float64(n),
+			saves: saves,
+		})
+	}
+	if a.wordRE != nil {
+		for _, m := range a.wordRE.FindAllStringIndex(text, -1) {
+			add(m[0], m[1], a.words[phraseKey(text[m[0]:m[1]])])
+		}
+	}
+	for _, p := range a.patterns {
+		for _, m := range p.re.FindAllStringSubmatchIndex(text, -1) {
+			short := string(p.re.ExpandString(nil, p.repl, text, m))
+			add(m[0], m[1], short)
+		}
+	}
+	if len(cands) == 0 {
+		return abbrevCandidate{}, false
+	}
+	best := cands[0]
+	for _, c := range cands[1:] {
+		switch {
+		case c.loss < best.loss,
+			c.loss == best.loss && c.saves > best.saves,
+			c.loss == best.loss && c.saves == best.saves && c.start < best.start:
+			best = c
+		}
+	}
+	return best, true
+}
+
+// matchCase gives short the case of long: all capitals if long is, the
+// capitals of the words if short is their initials, as for New York, or a
+// capital first letter if long has one.
+func matchCase(long, short string) string {
+	if words := strings.Fields(long); len(words) > 1 && utf8.RuneCountInString(short) == len(words) {
+		var b strings.Builder
+		for i, r := range []rune(short) {
+			first, _ := utf8.DecodeRuneInString(words[i])
+			if unicode.ToLower(first) != unicode.ToLower(r) {
+				b.Reset()
+				break
+			}
+			b.WriteRune(first)
+		}
+		if b.Len() > 0 {
+			return b.String()
+		}
+	}
+	letters, upper := 0, 0
+	for _, r := range long {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters > 1 && upper == letters {
+		return strings.ToUpper(short)
+	}
+	first, _ := utf8.DecodeRuneInString(long)
+	if !unicode.IsUpper(first) || short == "" {
+		return short
+	}
+	r, n := utf8.DecodeRuneInString(short)
+	return string(unicode.ToUpper(r)) + short[n:]
+}
+
+// Transform abbreviates the text as much as it needs to fit the display, as
+// laid out by LayoutFormatted with m.Format.
+func (a *Abbreviator) Transform(m *Message) {
+	d := m.Display
+	m.Text = a.Shorten(m.Text, func(s string) bool {
+		var l *Layout
+		if m.inPipeline {
+			// The text has been through the steps before this one
+			// already, and running them again would come back here.
+			l = d.layoutPrepared(s, m.Format)
+		} else {
+			l = d.LayoutFormatted(s, m.Format)
+		}
+		return l.Truncated == 0 && l.Dropped == 0
+	})
+}
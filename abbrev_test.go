@@ -0,0 +1,104 @@
+package flapper
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestShorten(t *testing.T) {
+	quake := NewAbbreviator(nil)
+	quake.AddPattern(regexp.MustCompile(`\d+ km [NSEW]+ of `), "")
+	tests := []struct {
+		name  string
+		a     *Abbreviator
+		text  string
+		width int
+		want  string
+	}{
+		{"fits already", NewAbbreviator(nil), "North Street", 12, "North Street"},
+		// street to st loses less than north to n.
+		{"least lossy first", NewAbbreviator(nil), "North Street", 10, "North St"},
+		{"only as needed", NewAbbreviator(nil), "North Street", 8, "North St"},
+		{"more when needed", NewAbbreviator(nil), "North Street", 7, "N St"},
+		{"phrase", NewAbbreviator(nil), "Albany, New York", 12, "Albany, NY"},
+		{"all caps", NewAbbreviator(nil), "MAIN STREET", 8, "MAIN ST"},
+		{"whole words only", NewAbbreviator(nil), "Streetcar", 5, "Streetcar"},
+		{"can't fit", NewAbbreviator(nil), "Tuesday Wednesday", 3, "Tue Wed"},
+		{"pattern last", quake, "4.5 150 km NE of Hihifo, Tonga", 24, "4.5 Hihifo, Tonga"},
+		{"pattern not needed", quake, "4.5 15 km N of Kodiak", 24, "4.5 15 km N of Kodiak"},
+		{"words before pattern", quake, "5 10 km S of Fox Islands", 22, "5 10 km S of Fox Is."},
+		{"custom", NewAbbreviator(map[string]string{"Earthquake": "quake"}),
+			"Big Earthquake", 11, "Big Quake"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.a.Shorten(tt.text, func(s string) bool {
+				return utf8.RuneCountInString(s) <= tt.width
+			})
+			if got != tt.want {
+				t.Errorf("Shorten(%q, %d) = %q, want %q", tt.text, tt.width, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadAbbreviations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "abbrevs.json")
+	err := os.WriteFile(path, []byte(`{"Earthquake": "quake", "north": "nth"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := LoadAbbreviations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := a.Shorten("north street earthquake", func(s string) bool { return len(s) <= 12 })
+	if want := "nth st quake"; got != want {
+		t.Errorf("Shorten = %q, want %q", got, want)
+	}
+}
+
+func TestAbbreviatorTransform(t *testing.T) {
+	d := newDisplay()
+	// Only islands needs shortening for this to fit in two rows.
+	got := d.PrepText(d.Transform("5.1 South Sandwich Islands", NewAbbreviator(nil)).Text)
+	if want := "5.1 south   \nsandwich is."; got != want {
+		t.Errorf("PrepText = %q, want %q", got, want)
+	}
+}
+
+func TestAbbreviatorUsesFormat(t *testing.T) {
+	d := newDisplay()
+	err := d.SetConfig(&Config{Flaps: DefaultFlaps + "-", Modules: 16, Columns: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAbbreviator(nil)
+	tests := []struct {
+		name string
+		f    *Format
+		want string
+	}{
+		// The word only fits if it's hyphenated.
+		{"hyphenated", &Format{Hyphenate: true}, "a inter-\nnational"},
+		{"not hyphenated", nil, "a intl  \n        "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := &TextOptions{Format: tt.f, Transform: a}
+			if got := d.LayoutTextOpts("a international", opts).Text; got != tt.want {
+				t.Errorf("LayoutTextOpts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The same goes for an abbreviator in the display's pipeline.
+	d.SetPipeline(Pipeline{DefaultPipeline, a})
+	if got, want := d.LayoutFormatted("a international", &Format{Hyphenate: true}).Text,
+		"a inter-\nnational"; got != want {
+		t.Errorf("LayoutFormatted = %q, want %q", got, want)
+	}
+}
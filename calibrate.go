@@ -0,0 +1,40 @@
+package flapper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/trapgate/flapper/proto"
+)
+
+// MoveToFlap sends one cell, given in layout order, to a flap. The flap index
+// is passed to the controller as it is, without the cell's calibration, so
+// this can be used to work out what the calibration should be. The other
+// modules are left alone.
+func (d *Display) MoveToFlap(cell, flap int) error {
+	return d.MoveToFlapContext(context.Background(), cell, flap)
+}
+
+// MoveToFlapContext is like MoveToFlap, but gives up when ctx is done.
+func (d *Display) MoveToFlapContext(ctx context.Context, cell, flap int) error {
+	mc := make([]*proto.SplitflapCommand_ModuleCommand, d.Modules())
+	if cell < 0 || cell >= len(mc) {
+		return fmt.Errorf("cell %d out of range; the display has %d modules", cell, len(mc))
+	}
+	if n := len([]rune(d.Flaps())); flap < 0 || flap >= n {
+		return fmt.Errorf("flap %d out of range; the display has %d flaps", flap, n)
+	}
+	for i := range mc {
+		mc[i] = &proto.SplitflapCommand_ModuleCommand{
+			Action: proto.SplitflapCommand_ModuleCommand_NO_OP,
+		}
+	}
+	p := d.physicalModules([]int{cell})[0]
+	if p >= len(mc) {
+		return fmt.Errorf("cell %d out of range; the display has %d modules", cell, len(mc))
+	}
+	mc[p].Action = proto.SplitflapCommand_ModuleCommand_GO_TO_FLAP
+	mc[p].Param = uint32(flap)
+
+	return d.sendModuleCommand(ctx, mc)
+}
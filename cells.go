@@ -0,0 +1,230 @@
+package flapper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/trapgate/flapper/proto"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// TextOptions changes how text is sent to the display. Only the modules whose
+// character changes are normally moved.
+type TextOptions struct {
+	// ForceRotation lists cells, in layout order, that go through a full
+	// rotation even if their character doesn't change.
+	ForceRotation []int
+	// RotateChangedWords makes every cell of a word that changes go through
+	// a full rotation, including letters that stay the same, so the word
+	// changes as a whole.
+	RotateChangedWords bool
+	// Full sends every cell, even the ones that aren't changing, for when
+	// the display's state isn't known. Unchanged cells only rotate if the
+	// ForceFullRotation setting is on.
+	Full bool
+	// Format controls how the text is laid out. It isn't used when there
+	// are dead cells to lay the text out around.
+	Format *Format
+	// Strict rejects text that doesn't fit the display exactly, returning a
+	// *FitError, instead of showing as much of it as possible.
+	Strict bool
+	// Transform, if set, prepares the text before the display's own
+	// pipeline does.
+	Transform Transformer
+}
+
+// SetCells changes individual cells, given in layout order, leaving the rest
+// of the display alone. Cells marked dead are left alone too.
+func (d *Display) SetCells(cells map[int]rune) error {
+	return d.SetCellsContext(context.Background(), cells)
+}
+
+// SetCellsContext is like SetCells, but gives up when ctx is done.
+func (d *Display) SetCellsContext(ctx context.Context, cells map[int]rune) error {
+	n := d.Modules()
+	want := make(map[int]rune)
+	for c, r := range cells {
+		if c < 0 || c >= n {
+			return fmt.Errorf("cell %d out of range; the display has %d modules", c, n)
+		}
+		// Normalizing can only change the rune, not add more.
+		if nr := []rune(d.normalize(string(r))); len(nr) == 1 {
+			r = nr[0]
+		}
+		want[c] = r
+	}
+	for _, c := range d.DeadCells() {
+		delete(want, c)
+	}
+	return d.sendCells(ctx, want, nil)
+}
+
+// SetRegion lays text out in a rectangle of the display, with its top left
+// cell at row and col, leaving the rest of the display alone. The text is
+// wrapped and padded to fill the rectangle, as SetText does for the whole
+// display. Cells marked dead are left alone.
+func (d *Display) SetRegion(row, col, width, height int, text string) error {
+	return d.SetRegionContext(context.Background(), row, col, width, height, text)
+}
+
+// SetRegionContext is like SetRegion, but gives up when ctx is done.
+func (d *Display) SetRegionContext(ctx context.Context, row, col, width, height int, text string) error {
+	g := d.Geometry()
+	if row < 0 || col < 0 || width < 1 || height < 1 ||
+		row+height > g.Rows || col+width > g.Columns {
+		return fmt.Errorf("region %dx%d at row %d, column %d doesn't fit the %v display",
+			height, width, row, col, g)
+	}
+	want := make(map[int]rune)
+	lines, _, _ := d.prepLines(text, width, height, nil)
+	for y, line := range lines {
+		for x, r := range []rune(line) {
+			want[g.Module(row+y, col+x)] = r
+		}
+	}
+	for _, c := range d.DeadCells() {
+		delete(want, c)
+	}
+	return d.sendCells(ctx, want, nil)
+}
+
+// currentFlaps returns the flap each module is showing, or on its way to, by
+// position in the chain. It's -1 for modules whose flap isn't known. A
+// module's target is only used until the controller reports it stopped
+// somewhere else.
+func (d *Display) currentFlaps() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cur := make([]int, d.cells)
+	for p := range cur {
+		cur[p] = -1
+		if p < len(d.targets) && d.targets[p].flap >= 0 {
+			cur[p] = d.targets[p].flap
+		} else if p < len(d.lastStatus.Modules) && !d.lastStatus.Modules[p].Moving {
+			cur[p] = int(d.lastStatus.Modules[p].FlapIndex)
+		}
+	}
+	return cur
+}
+
+// fullRotationSettings returns the settings in force, and whether they have
+// the ForceFullRotation setting on.
+func (d *Display) fullRotationSettings() (*proto.Settings, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	settings := d.currentSettings()
+	return settings, settings.ForceFullRotation
+}
+
+// sendCells moves the cells in want, given in layout order, to show their
+// runes. Other cells, and cells already showing their rune, are sent a NO_OP.
+func (d *Display) sendCells(ctx context.Context, want map[int]rune, opts *TextOptions) error {
+	if opts == nil {
+		opts = &TextOptions{}
+	}
+	g := d.Geometry()
+	phys := d.physicalModules(d.allModules())
+	n := len(phys)
+	cur := d.currentFlaps()
+	settings, forceAll := d.fullRotationSettings()
+
+	d.mu.Lock()
+	if d.wanted == nil {
+		d.wanted = make(map[int]rune)
+	}
+	for c, r := range want {
+		d.wanted[c] = r
+	}
+	d.mu.Unlock()
+
+	// Work out the flaps, and which cells are changing, in layout order.
+	flaps := make([]int, n)
+	changed := make([]bool, n)
+	force := make([]bool, n)
+	for i := range flaps {
+		flaps[i] = -1
+		r, ok := want[i]
+		if !ok {
+			continue
+		}
+		flap, ok := d.flapIndex(i, r)
+		if !ok {
+			flap, _ = d.flapIndex(i, ' ')
+		}
+		flaps[i] = flap
+		changed[i] = phys[i] >= len(cur) || cur[phys[i]] != flap
+		force[i] = forceAll || opts.Full
+	}
+	for _, c := range opts.ForceRotation {
+		if c >= 0 && c < n {
+			force[c] = true
+		}
+	}
+	if opts.RotateChangedWords {
+		forceChangedWords(g, want, changed, force)
+	}
+
+	mc := make([]*proto.SplitflapCommand_ModuleCommand, n)
+	for p := range mc {
+		mc[p] = &proto.SplitflapCommand_ModuleCommand{
+			Action: proto.SplitflapCommand_ModuleCommand_NO_OP,
+		}
+	}
+	rotate := false
+	for i := range flaps {
+		// The module count can change under us if the controller reports
+		// a different one; leave out modules that are no longer there.
+		p := phys[i]
+		if p >= n || flaps[i] < 0 || (!changed[i] && !force[i]) {
+			continue
+		}
+		mc[p].Action = proto.SplitflapCommand_ModuleCommand_GO_TO_FLAP
+		mc[p].Param = uint32(flaps[i])
+		rotate = rotate || !changed[i]
+	}
+	if !rotate || forceAll || opts.Full {
+		return d.sendModuleCommand(ctx, mc)
+	}
+
+	// The controller only rotates a module sent to the flap it's already on
+	// if ForceFullRotation is on, so turn it on just for this command.
+	on := gproto.Clone(settings).(*proto.Settings)
+	on.ForceFullRotation = true
+	err := d.sendSettings(ctx, on)
+	if err != nil {
+		return err
+	}
+	err = d.sendModuleCommand(ctx, mc)
+	// Put the setting back even if the command failed.
+	if serr := d.sendSettings(ctx, settings); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// forceChangedWords marks every cell of a word in want as forced if any of
+// its cells is changing. Words are runs of non-blank cells within a row.
+func forceChangedWords(g Geometry, want map[int]rune, changed, force []bool) {
+	for row := 0; row < g.Rows; row++ {
+		start := -1
+		for col := 0; col <= g.Columns; col++ {
+			i := g.Module(row, col)
+			r, ok := want[i]
+			inWord := col < g.Columns && ok && r != ' '
+			if inWord && start < 0 {
+				start = col
+			}
+			if inWord || start < 0 {
+				continue
+			}
+			hit := false
+			for c := start; c < col; c++ {
+				hit = hit || changed[g.Module(row, c)]
+			}
+			for c := start; hit && c < col; c++ {
+				force[g.Module(row, c)] = true
+			}
+			start = -1
+		}
+	}
+}
@@ -4,21 +4,31 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/alecthomas/kong"
 	"github.com/trapgate/flapper"
 	"github.com/trapgate/flapper/idle"
+	"github.com/trapgate/flapper/proto"
 )
 
 const (
 	defaultIdlerDelay = 10 * time.Minute
+
+	// settleTimeout is the longest to wait for the flaps to arrive when
+	// showing multi-line text.
+	settleTimeout = 30 * time.Second
 )
 
 var (
@@ -26,9 +36,15 @@ var (
 )
 
 type serveCmd struct {
+	Abbreviations string `help:"Abbreviations to use for text that doesn't fit, as a JSON object mapping words to their abbreviations. They're added to the built-in ones." type:"path"`
+
 	d           *flapper.Display
+	abbrev      *flapper.Abbreviator
 	idler       idle.Display
 	cancelIdler context.CancelFunc
+
+	mu        sync.Mutex
+	connSince time.Time // When the connection state last changed.
 }
 
 type displayCmd struct {
@@ -38,10 +54,34 @@ type displayCmd struct {
 type statusCmd struct {
 }
 
+type portsCmd struct {
+}
+
+type resetCmd struct {
+	Modules []int `arg:"" optional:"" help:"Modules to reset. All modules are reset if none are given."`
+}
+
+type calibrateCmd struct {
+	Cell int    `arg:"" help:"Cell to calibrate, counting across each row from the top left."`
+	Char string `default:"a" help:"Character to line the module up with."`
+}
+
+type identifyCmd struct {
+	Tens bool `help:"Show the tens digit of each module's position instead of the last digit."`
+}
+
 var cli struct {
-	Serve   serveCmd   `cmd:"" help:"Listen on http for strings to display." default:"1"`
-	Display displayCmd `cmd:"" help:"Display a string on the splitflaps."`
-	Status  statusCmd  `cmd:"" help:"Display the status of the splitflaps."`
+	Device   string   `help:"The tty device the controller is attached to. If not set, USB devices are searched for the controller."`
+	Config   string   `help:"Display configuration file, in JSON." type:"path"`
+	USBMatch []string `name:"usb-match" help:"USB devices to search, as VID:PID or VID:PID:SERIAL."`
+
+	Serve     serveCmd     `cmd:"" help:"Listen on http for strings to display." default:"1"`
+	Display   displayCmd   `cmd:"" help:"Display a string on the splitflaps."`
+	Status    statusCmd    `cmd:"" help:"Display the status of the splitflaps."`
+	Ports     portsCmd     `cmd:"" help:"List the serial ports that might have a controller attached."`
+	Reset     resetCmd     `cmd:"" help:"Reset modules and make them find their home position."`
+	Identify  identifyCmd  `cmd:"" help:"Show each module's position in the chain, to help build a module map."`
+	Calibrate calibrateCmd `cmd:"" help:"Work out the flap offset of a module that homes on the wrong flap."`
 }
 
 func main() {
@@ -51,17 +91,33 @@ func main() {
 }
 
 func (c *serveCmd) Run(ctx *kong.Context) error {
-	d, err := flapper.NewDisplay()
+	d, err := openDisplay()
 	if err != nil {
 		return err
 	}
 	d.Init()
 	c.d = d
+	c.abbrev = flapper.NewAbbreviator(nil)
+	if c.Abbreviations != "" {
+		c.abbrev, err = flapper.LoadAbbreviations(c.Abbreviations)
+		if err != nil {
+			return err
+		}
+	}
+	c.connSince = time.Now()
+	d.OnConnStateChange(func(state flapper.ConnState) {
+		c.mu.Lock()
+		c.connSince = time.Now()
+		c.mu.Unlock()
+	})
 
 	fmt.Println("listening on port 8080")
 	http.HandleFunc("/text", c.httpText)
 	http.HandleFunc("/status", c.httpStatus)
 	http.HandleFunc("/idle", c.httpIdle)
+	http.HandleFunc("/reset", c.httpReset)
+	http.HandleFunc("/logs", c.httpLogs)
+	http.HandleFunc("/deadcells", c.httpDeadCells)
 
 	// Set up the "screensaver"
 	c.idler = idle.NewQuakeMon(defaultIdlerDelay)
@@ -78,11 +134,7 @@ func (c *serveCmd) Run(ctx *kong.Context) error {
 func (c *serveCmd) httpText(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		text := c.d.Text()
-		if len(text) > 12 {
-			text = text[:12] + "\n" + text[12:]
-		}
-		fmt.Fprintf(w, "%v", text)
+		fmt.Fprintf(w, "%v", c.d.Text())
 	case http.MethodPost:
 		c.idler.Reset()
 		// maxmoving will limit the number of displays that animate at a time.
@@ -91,7 +143,7 @@ func (c *serveCmd) httpText(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			err = c.d.SetMaxMoving(uint32(maxMoving))
+			err = c.d.SetMaxMovingContext(r.Context(), uint32(maxMoving))
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				return
@@ -104,7 +156,7 @@ func (c *serveCmd) httpText(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			err = c.d.SetForceRotation(fullRotation)
+			err = c.d.SetForceRotationContext(r.Context(), fullRotation)
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				return
@@ -118,7 +170,7 @@ func (c *serveCmd) httpText(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			err = c.d.SetStartDelay(uint32(startDelay))
+			err = c.d.SetStartDelayContext(r.Context(), uint32(startDelay))
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				return
@@ -132,20 +184,126 @@ func (c *serveCmd) httpText(w http.ResponseWriter, r *http.Request) {
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			err = c.d.SetAnimStyle(animStyle)
+			err = c.d.SetAnimStyleContext(r.Context(), animStyle)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		// rotate lists cells that should go through a full rotation even if
+		// their character isn't changing, or is "words" to rotate every cell
+		// of each word that changes.
+		opts := &flapper.TextOptions{}
+		if r.PostFormValue("rotate") == "words" {
+			opts.RotateChangedWords = true
+		} else if cells, err := readFormInts(r, "rotate"); err != errNoFormValue {
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			opts.ForceRotation = cells
+		}
+		// abbreviate shortens text that doesn't fit the display.
+		if abbreviate, err := readFormBool(r, "abbreviate"); err != errNoFormValue {
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if abbreviate {
+				opts.Transform = c.abbrev
+			}
+		}
+		// strict rejects text that doesn't fit the display exactly.
+		if strict, err := readFormBool(r, "strict"); err != errNoFormValue {
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			opts.Strict = strict
+		}
+
+		// align gives the alignment of each row of text, separated by commas,
+		// vcenter centers the text vertically, and hyphenate breaks long words
+		// between syllables.
+		format := &flapper.Format{}
+		if align, err := readFormString(r, "align"); err != errNoFormValue {
+			for _, s := range strings.Split(align, ",") {
+				a, err := flapper.ParseAlign(strings.TrimSpace(s))
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				format.Align = append(format.Align, a)
+			}
+		}
+		if vcenter, err := readFormBool(r, "vcenter"); err != errNoFormValue {
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			format.VerticalCenter = vcenter
+		}
+		if hyphenate, err := readFormBool(r, "hyphenate"); err != errNoFormValue {
 			if err != nil {
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
+			format.Hyphenate = hyphenate
 		}
+		opts.Format = format
 
 		// Features to add:
-		// - Move the word left across the display. Start the letters of the
-		//   word and the cell to the left animating so that they finish at the
-		//   same time.
 		// - Fall letters in from the top row to the bottom.
 
-		// For multi-line text, delay between each line.
+		// mode is "pages" to split text too long for the display into pages,
+		// or "marquee" to scroll it across a row. Either way, dwell is how
+		// long each page or step stays up after its flaps have arrived, and
+		// row is the row a marquee scrolls along.
+		switch mode := r.PostFormValue("mode"); mode {
+		case "", "lines":
+		case "pages", "marquee":
+			dwell := 5 * time.Second
+			if mode == "marquee" {
+				dwell = 0
+			}
+			if dwellStr := r.PostFormValue("dwell"); dwellStr != "" {
+				var err error
+				dwell, err = time.ParseDuration(dwellStr)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+			}
+			text := r.PostFormValue("text")
+			if mode == "pages" {
+				layouts, err := c.d.ShowPages(r.Context(), text, dwell, opts)
+				if err != nil {
+					fmt.Println(err)
+					writeCmdError(w, err)
+					return
+				}
+				writeLayouts(w, layouts)
+				return
+			}
+			row, err := readFormInt(r, "row")
+			if (err != nil && err != errNoFormValue) || row < 0 || row >= c.d.Geometry().Rows {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			err = c.d.ShowMarquee(r.Context(), text, row, dwell, opts)
+			if err != nil {
+				fmt.Println(err)
+				writeCmdError(w, err)
+			}
+			return
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// For multi-line text, each line stays up for this long after its flaps
+		// have arrived.
 		delay := 5 * time.Second
 		delayStr := r.PostFormValue("delay")
 		if delayStr != "" {
@@ -156,15 +314,60 @@ func (c *serveCmd) httpText(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
+		// Each line of the text is shown in turn. Within a line, rowbreak, if
+		// it's set, starts a new row.
 		lines := strings.Split(r.PostFormValue("text"), "\n")
+		if rowBreak := r.PostFormValue("rowbreak"); rowBreak != "" {
+			for i := range lines {
+				lines[i] = strings.ReplaceAll(lines[i], rowBreak, "\n")
+			}
+		}
 		fmt.Println(lines)
+		if opts.Strict {
+			// Check every line before showing any of them.
+			for _, line := range lines {
+				if layout := c.d.LayoutTextOpts(line, opts); !layout.Fits() {
+					writeCmdError(w, &flapper.FitError{Layout: layout})
+					return
+				}
+			}
+		}
+		var layouts []*flapper.Layout
 		for i, line := range lines {
-			err := c.d.SetText(line)
-			if err != nil {
+			if i+1 == len(lines) {
+				// Nothing follows the last line, so there's no need to wait for
+				// it to arrive.
+				layout, err := c.d.ShowTextOpts(r.Context(), line, opts)
+				if err != nil {
+					fmt.Println(err)
+					writeCmdError(w, err)
+					return
+				}
+				writeLayouts(w, append(layouts, layout))
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), settleTimeout)
+			layout, err := c.d.ShowTextOpts(ctx, line, opts)
+			if err == nil {
+				layouts = append(layouts, layout)
+				err = c.d.WaitSettled(ctx)
+			}
+			cancel()
+			var settleErr *flapper.SettleError
+			if errors.As(err, &settleErr) {
+				// Some modules are stuck; show the rest of the text anyway.
+				fmt.Println(err)
+			} else if err != nil {
 				fmt.Println(err)
+				writeCmdError(w, err)
+				return
 			}
-			if i+1 < len(lines) {
-				time.Sleep(delay)
+
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
 			}
 		}
 	}
@@ -173,10 +376,212 @@ func (c *serveCmd) httpText(w http.ResponseWriter, r *http.Request) {
 func (c *serveCmd) httpStatus(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
+		c.mu.Lock()
+		since := c.connSince
+		c.mu.Unlock()
+		fmt.Fprintf(w, "connection: %v since %v\n", c.d.ConnState(),
+			since.Format(time.RFC3339))
+		fmt.Fprintf(w, "modules: %d geometry: %v\n", c.d.Modules(), c.d.Geometry())
+		writeSupervisor(w, c.d.Supervisor())
 		fmt.Fprintf(w, "%v", c.d.Status())
 	}
 }
 
+// writeLayouts reports how well each line of text fit the display, and the
+// dead cells that any of them were laid out around.
+func writeLayouts(w io.Writer, layouts []*flapper.Layout) {
+	skipped := make(map[int]bool)
+	for i, l := range layouts {
+		for _, cell := range l.Skipped {
+			skipped[cell] = true
+		}
+		prefix := ""
+		if len(layouts) > 1 {
+			prefix = fmt.Sprintf("line %d: ", i+1)
+		}
+		writeFit(w, prefix, l)
+	}
+	if len(skipped) == 0 {
+		return
+	}
+	cells := make([]int, 0, len(skipped))
+	for cell := range skipped {
+		cells = append(cells, cell)
+	}
+	sort.Ints(cells)
+	fmt.Fprintln(w, "skipped cells:", strings.Trim(fmt.Sprint(cells), "[]"))
+}
+
+// writeFit reports the characters in a layout that weren't shown as they
+// should have been.
+func writeFit(w io.Writer, prefix string, l *flapper.Layout) {
+	for _, s := range l.Substituted {
+		fmt.Fprintf(w, "%ssubstituted %q for %q\n", prefix, s.To, s.From)
+	}
+	for _, u := range l.Unsupported {
+		fmt.Fprintf(w, "%sunsupported character %q at cell %d\n", prefix, u.Rune, u.Cell)
+	}
+	if l.Truncated > 0 {
+		fmt.Fprintf(w, "%struncated %d characters\n", prefix, l.Truncated)
+	}
+	if l.Dropped > 0 {
+		fmt.Fprintf(w, "%sdropped %d lines\n", prefix, l.Dropped)
+	}
+}
+
+// httpDeadCells returns the cells that text is laid out around. A POST sets
+// the cells marked dead with the "cells" form value, separated by commas, or
+// clears them if it isn't set. Cells in the configuration, or with faulty
+// modules, can't be cleared this way.
+func (c *serveCmd) httpDeadCells(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		cells, err := readFormInts(r, "cells")
+		if err != nil && err != errNoFormValue {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err = c.d.SetDeadCells(cells...)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, err)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	fmt.Fprintln(w, strings.Trim(fmt.Sprint(c.d.DeadCells()), "[]"))
+}
+
+// httpReset resets the modules listed in the "modules" form value, separated
+// by commas, or every module if it isn't set.
+func (c *serveCmd) httpReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	modules, err := readFormInts(r, "modules")
+	if err != nil && err != errNoFormValue {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if len(modules) == 0 {
+		err = c.d.ResetAllContext(r.Context())
+	} else {
+		err = c.d.ResetModulesContext(r.Context(), modules...)
+	}
+	if err != nil {
+		fmt.Println(err)
+		writeCmdError(w, err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// httpLogs returns the log messages from the controller firmware. The "since"
+// parameter limits them to messages received after a time, given either in
+// RFC3339 format or as a duration before now, like "10m". If "follow" is
+// true, new messages are streamed as they arrive.
+func (c *serveCmd) httpLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var since time.Time
+	if s := r.FormValue("since"); s != "" {
+		var err error
+		since, err = parseSince(s)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	follow := false
+	if f := r.FormValue("follow"); f != "" {
+		var err error
+		follow, err = strconv.ParseBool(f)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Subscribe before reading the backlog so nothing falls in between. The
+	// subscription is only used to learn that there are new messages; they're
+	// read from the display's log so that they have receive times.
+	var sub *flapper.Subscription
+	if follow {
+		sub = c.d.Subscribe(flapper.LogMessages, 1)
+		defer sub.Close()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	var last uint64
+	for _, e := range c.d.LogsSince(since) {
+		writeLogEntry(w, e)
+		last = e.Seq
+	}
+	if !follow {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case _, ok := <-sub.C:
+			if !ok {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		for _, e := range c.d.Logs() {
+			if e.Seq > last {
+				writeLogEntry(w, e)
+				last = e.Seq
+			}
+		}
+	}
+}
+
+// parseSince parses a time given either in RFC3339 format, or as a duration
+// before now.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func writeLogEntry(w io.Writer, e flapper.LogEntry) {
+	fmt.Fprintf(w, "%v %v\n", e.Time.Format(time.RFC3339Nano), e.Msg)
+}
+
+// writeSupervisor writes a summary of the power supervisor's latest report.
+func writeSupervisor(w io.Writer, st *proto.SupervisorState) {
+	if st == nil {
+		fmt.Fprintln(w, "supervisor: no report")
+		return
+	}
+	uptime := time.Duration(st.UptimeMillis) * time.Millisecond
+	fmt.Fprintf(w, "supervisor: %v, uptime %v, %v\n", st.State, uptime,
+		flapper.FaultString(st))
+	for i, ch := range st.PowerChannels {
+		on := "off"
+		if ch.On {
+			on = "on"
+		}
+		fmt.Fprintf(w, "  channel %d: %v %.2fV %.2fA\n", i, on,
+			ch.VoltageVolts, ch.CurrentAmps)
+	}
+}
+
 func (c *serveCmd) httpIdle(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -193,6 +598,29 @@ func (c *serveCmd) httpIdle(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// writeCmdError reports an error from a display command to the http client.
+// Commands the display never acknowledged are reported as a gateway timeout.
+func writeCmdError(w http.ResponseWriter, err error) {
+	var homeErr *flapper.HomeError
+	var fitErr *flapper.FitError
+	switch {
+	case errors.As(err, &fitErr):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintln(w, err)
+		writeFit(w, "", fitErr.Layout)
+		return
+	case errors.As(err, &homeErr):
+		w.WriteHeader(http.StatusConflict)
+	case errors.Is(err, flapper.ErrNotAcknowledged):
+		w.WriteHeader(http.StatusGatewayTimeout)
+	case errors.Is(err, flapper.ErrDisconnected):
+		w.WriteHeader(http.StatusServiceUnavailable)
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	fmt.Fprintln(w, err)
+}
+
 func readFormInt(r *http.Request, valName string) (int, error) {
 	valStr := r.PostFormValue(valName)
 	if valStr == "" {
@@ -205,6 +633,23 @@ func readFormInt(r *http.Request, valName string) (int, error) {
 	return int(val), nil
 }
 
+// readFormInts reads a comma separated list of integers from a form.
+func readFormInts(r *http.Request, valName string) ([]int, error) {
+	valStr := r.PostFormValue(valName)
+	if valStr == "" {
+		return nil, errNoFormValue
+	}
+	var vals []int
+	for _, s := range strings.Split(valStr, ",") {
+		val, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, val)
+	}
+	return vals, nil
+}
+
 func readFormUint(r *http.Request, valName string) (uint, error) {
 	valStr := r.PostFormValue(valName)
 	if valStr == "" {
@@ -242,8 +687,56 @@ func readFormString(r *http.Request, valName string) (string, error) {
 	return valStr, nil
 }
 
+// openDisplay connects to the display using the device given on the command
+// line, or by searching for it, and applies the display configuration.
+func openDisplay() (*flapper.Display, error) {
+	cfg := &flapper.Config{}
+	if cli.Config != "" {
+		var err error
+		cfg, err = flapper.LoadConfig(cli.Config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var d *flapper.Display
+	var err error
+	if cli.Device != "" {
+		d, err = flapper.NewDisplayOnDevice(cli.Device)
+	} else {
+		var matches []flapper.USBMatch
+		matches, err = usbMatches()
+		if err != nil {
+			return nil, err
+		}
+		d, err = flapper.NewDisplayMatching(matches)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.SetConfig(cfg)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func usbMatches() ([]flapper.USBMatch, error) {
+	var matches []flapper.USBMatch
+	for _, s := range cli.USBMatch {
+		m, err := flapper.ParseUSBMatch(s)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
 func (c *displayCmd) Run(ctx *kong.Context) error {
-	d, err := flapper.NewDisplay()
+	d, err := openDisplay()
 	if err != nil {
 		return err
 	}
@@ -253,11 +746,172 @@ func (c *displayCmd) Run(ctx *kong.Context) error {
 }
 
 func (c *statusCmd) Run(ctx *kong.Context) error {
-	d, err := flapper.NewDisplay()
+	d, err := openDisplay()
 	if err != nil {
 		return err
 	}
 	d.Init()
+	fmt.Println("connection:", d.ConnState())
+	fmt.Printf("modules: %d geometry: %v\n", d.Modules(), d.Geometry())
+	writeSupervisor(os.Stdout, d.Supervisor())
 	fmt.Println(d.Status())
 	return nil
 }
+
+func (c *portsCmd) Run(ctx *kong.Context) error {
+	matches, err := usbMatches()
+	if err != nil {
+		return err
+	}
+	ports, err := flapper.ListPorts(matches)
+	if err != nil {
+		return err
+	}
+	for _, p := range ports {
+		if !p.Matched {
+			fmt.Println("  ", p)
+			continue
+		}
+		result := "controller found"
+		if err := flapper.Probe(p.Name); err != nil {
+			result = err.Error()
+		}
+		fmt.Printf("* %v: %v\n", p, result)
+	}
+	return nil
+}
+
+func (c *resetCmd) Run(ctx *kong.Context) error {
+	d, err := openDisplay()
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if len(c.Modules) == 0 {
+		err = d.ResetAll()
+	} else {
+		err = d.ResetModules(c.Modules...)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("reset complete")
+	return nil
+}
+
+func (c *identifyCmd) Run(ctx *kong.Context) error {
+	d, err := openDisplay()
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	wctx, cancel := context.WithTimeout(context.Background(), settleTimeout)
+	defer cancel()
+	err = d.IdentifyContext(wctx, c.Tens)
+	if err != nil {
+		return err
+	}
+	err = d.WaitSettled(wctx)
+	if err != nil {
+		fmt.Println(err)
+	}
+	if c.Tens {
+		fmt.Println("each module is showing the tens digit of its position in the chain")
+	} else {
+		fmt.Println("each module is showing the last digit of its position in the chain;")
+		fmt.Println("run again with --tens to see the tens digits")
+	}
+	return nil
+}
+
+func (c *calibrateCmd) Run(ctx *kong.Context) error {
+	d, err := openDisplay()
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if c.Cell < 0 || c.Cell >= d.Modules() {
+		return fmt.Errorf("cell %d out of range; the display has %d modules", c.Cell, d.Modules())
+	}
+	target := []rune(c.Char)
+	if len(target) != 1 {
+		return fmt.Errorf("--char must be a single character")
+	}
+	cal := d.Config().Calibration[c.Cell]
+	flaps := []rune(cal.Flaps)
+	if len(flaps) == 0 {
+		flaps = []rune(d.Flaps())
+	}
+	idx := -1
+	for i, r := range flaps {
+		if r == target[0] {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return fmt.Errorf("the display has no flap for %q", target[0])
+	}
+
+	// The module is stepped on one flap at a time by reducing the offset,
+	// until it shows the target.
+	in := bufio.NewReader(os.Stdin)
+	offset := cal.Offset
+	for {
+		flap := ((idx-offset)%len(flaps) + len(flaps)) % len(flaps)
+		wctx, cancel := context.WithTimeout(context.Background(), settleTimeout)
+		err = d.MoveToFlapContext(wctx, c.Cell, flap)
+		if err == nil {
+			err = d.WaitSettled(wctx)
+		}
+		cancel()
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		fmt.Printf("cell %d should show %q. Press enter to step it on a flap, "+
+			"y if it's right, or q to quit: ", c.Cell, target[0])
+		line, err := in.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		switch strings.TrimSpace(line) {
+		case "":
+			offset--
+			continue
+		case "y", "Y":
+		case "q", "Q":
+			return nil
+		default:
+			continue
+		}
+		break
+	}
+
+	// Keep the offset small, so it's easy to read in the configuration.
+	offset %= len(flaps)
+	if offset > len(flaps)/2 {
+		offset -= len(flaps)
+	} else if offset < -len(flaps)/2 {
+		offset += len(flaps)
+	}
+	cal.Offset = offset
+	err = d.SetCalibration(c.Cell, cal)
+	if err != nil {
+		return err
+	}
+	if cli.Config == "" {
+		fmt.Printf("cell %d has an offset of %d; add it to the \"calibration\" section "+
+			"of the display configuration\n", c.Cell, offset)
+		return nil
+	}
+	cfg := d.Config()
+	err = flapper.SaveConfig(cli.Config, &cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("cell %d has an offset of %d; saved to %v\n", c.Cell, offset, cli.Config)
+	return nil
+}
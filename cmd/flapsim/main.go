@@ -0,0 +1,94 @@
+// Package main implements flapsim, a splitflap controller simulator. It
+// creates a pseudo-terminal and speaks the same framed protobuf protocol as
+// the splitflap firmware on the other end of it, so flapperd can be run
+// against the pty without any hardware attached. Faults can be injected to
+// exercise error handling.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/alecthomas/kong"
+)
+
+type config struct {
+	Modules int `default:"24" help:"Number of simulated modules."`
+	Columns int `default:"12" help:"Number of modules in each row, used by the DOWN and UP animation styles."`
+	Flaps   int `default:"40" help:"Number of flaps on each module."`
+
+	StepTime         time.Duration `default:"60ms" help:"Time taken for a module to advance one flap."`
+	StatePeriod      time.Duration `default:"1s" help:"How often to send SplitflapState when nothing is changing."`
+	SupervisorPeriod time.Duration `default:"2s" help:"How often to send SupervisorState."`
+	LogPeriod        time.Duration `default:"30s" help:"How often to send a heartbeat Log message."`
+
+	SensorError     []int   `help:"Modules whose home sensor is broken. They will fail to home and report SENSOR_ERROR."`
+	MissHome        float64 `help:"Probability that a module misses its home position on each rotation."`
+	DropAcks        float64 `help:"Probability that a received message is not acked."`
+	Corrupt         float64 `help:"Probability that a sent frame is corrupted."`
+	SupervisorFault string  `help:"Report this power supervisor fault (e.g. OVER_CURRENT) instead of NORMAL."`
+
+	Link string `help:"Create a symlink to the pty at this path."`
+}
+
+var cli config
+
+func main() {
+	ctx := kong.Parse(&cli,
+		kong.Description("Simulate a splitflap controller on a pseudo-terminal."))
+	err := run(&cli)
+	ctx.FatalIfErrorf(err)
+}
+
+func run(cfg *config) error {
+	if cfg.Modules <= 0 || cfg.Flaps <= 0 || cfg.Columns <= 0 {
+		return fmt.Errorf("modules, columns and flaps must be positive")
+	}
+
+	master, slave, path, err := openPty()
+	if err != nil {
+		return err
+	}
+	defer master.Close()
+	defer slave.Close()
+
+	if cfg.Link != "" {
+		os.Remove(cfg.Link)
+		if err := os.Symlink(path, cfg.Link); err != nil {
+			return err
+		}
+		defer os.Remove(cfg.Link)
+		fmt.Printf("simulating %d modules on %v (%v)\n", cfg.Modules, cfg.Link, path)
+	} else {
+		fmt.Printf("simulating %d modules on %v\n", cfg.Modules, path)
+	}
+
+	sim := newSimulator(cfg)
+	go sim.writeFrames(master)
+
+	frames := make(chan []byte)
+	go readFrames(master, frames)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	sim.run(frames, sigs)
+	return nil
+}
+
+// readFrames splits the bytes written by the client into zero-terminated
+// frames and sends them to frames.
+func readFrames(master *os.File, frames chan<- []byte) {
+	defer close(frames)
+	rdr := bufio.NewReader(master)
+	for {
+		b, err := rdr.ReadBytes(0)
+		if err != nil {
+			fmt.Println("failed to read from pty:", err)
+			return
+		}
+		frames <- b
+	}
+}
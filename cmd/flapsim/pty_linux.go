@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPty creates a new pseudo-terminal and puts it in raw mode. It returns
+// the master side, which the simulator reads and writes, the slave side, and
+// the path of the slave device that clients should open. The caller should
+// keep the slave open for as long as the simulator runs; otherwise reads from
+// the master fail whenever no client is connected.
+func openPty() (master, slave *os.File, path string, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	fd := int(master.Fd())
+	if err = unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		return nil, nil, "", fmt.Errorf("failed to unlock pty: %w", err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, "", fmt.Errorf("failed to get pty number: %w", err)
+	}
+	path = fmt.Sprintf("/dev/pts/%d", n)
+
+	slave, err = os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, "", err
+	}
+	if err = makeRaw(int(slave.Fd())); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, nil, "", err
+	}
+	return master, slave, path, nil
+}
+
+// makeRaw turns off all of the line discipline's processing, so the frames
+// pass through the pty untouched.
+func makeRaw(fd int) error {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+		unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
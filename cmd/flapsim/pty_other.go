@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func openPty() (master, slave *os.File, path string, err error) {
+	return nil, nil, "", errors.New("flapsim only runs on linux")
+}
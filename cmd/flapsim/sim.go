@@ -0,0 +1,503 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/trapgate/flapper"
+	"github.com/trapgate/flapper/proto"
+)
+
+const (
+	// maxMisses is the number of times in a row a module can fail to find
+	// its home position before it gives up and reports a sensor error.
+	maxMisses = 3
+)
+
+// module is the simulated state of a single splitflap module.
+type module struct {
+	state  proto.SplitflapState_ModuleState_State
+	flap   int  // the flap currently showing
+	target int  // the flap the module is moving to
+	steps  int  // flaps left to travel before stopping
+	queued bool // waiting to start moving
+	moving bool
+	homing bool // moving because of a reset, rather than a command
+	broken bool // the home sensor never triggers
+	misses int  // consecutive rotations without seeing home
+
+	countMissedHome     uint32
+	countUnexpectedHome uint32
+
+	// The last nonces seen in a SplitflapConfig for this module.
+	movementNonce uint32
+	resetNonce    uint32
+}
+
+// simulator emulates the splitflap controller firmware. All of its state is
+// owned by the goroutine running run.
+type simulator struct {
+	cfg      *config
+	rng      *rand.Rand
+	out      chan []byte
+	booted   time.Time
+	settings *proto.Settings
+	modules  []*module
+
+	pending   [][]int // groups of modules waiting to start, in start order
+	nextStart time.Time
+	changed   bool // state changed since the last SplitflapState was sent
+}
+
+func newSimulator(cfg *config) *simulator {
+	s := &simulator{
+		cfg:      cfg,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		out:      make(chan []byte, 64),
+		booted:   time.Now(),
+		settings: &proto.Settings{},
+	}
+	for i := 0; i < cfg.Modules; i++ {
+		s.modules = append(s.modules, &module{})
+	}
+	for _, i := range cfg.SensorError {
+		if i >= 0 && i < len(s.modules) {
+			s.modules[i].broken = true
+		}
+	}
+	return s
+}
+
+// run is the simulator's main loop. It returns when the frames channel is
+// closed or a signal arrives.
+func (s *simulator) run(frames <-chan []byte, sigs <-chan os.Signal) {
+	step := time.NewTicker(s.cfg.StepTime)
+	defer step.Stop()
+	state := time.NewTicker(s.cfg.StatePeriod)
+	defer state.Stop()
+	supervisor := time.NewTicker(s.cfg.SupervisorPeriod)
+	defer supervisor.Stop()
+	heartbeat := time.NewTicker(s.cfg.LogPeriod)
+	defer heartbeat.Stop()
+
+	// Like the real firmware, every module homes itself at power on.
+	s.log("splitflap simulator starting, %d modules", len(s.modules))
+	var all []int
+	for i := range s.modules {
+		s.reset(i)
+		all = append(all, i)
+	}
+	s.schedule(all, time.Now())
+
+	for {
+		select {
+		case f, ok := <-frames:
+			if !ok {
+				return
+			}
+			s.handleFrame(f)
+		case now := <-step.C:
+			s.step(now)
+		case <-state.C:
+			s.changed = true
+		case <-supervisor.C:
+			s.sendSupervisorState()
+		case <-heartbeat.C:
+			s.log("uptime %v, %d modules moving",
+				time.Since(s.booted).Round(time.Second), s.movingCount())
+		case <-sigs:
+			fmt.Println("shutting down")
+			return
+		}
+
+		if s.changed {
+			s.sendState()
+			s.changed = false
+		}
+	}
+}
+
+func (s *simulator) handleFrame(b []byte) {
+	msg := &proto.ToSplitflap{}
+	if err := flapper.UnmarshalFrame(b, msg); err != nil {
+		s.log("dropped bad frame: %v", err)
+		return
+	}
+	fmt.Println("received", msg)
+
+	if s.rng.Float64() < s.cfg.DropAcks {
+		fmt.Println("dropping ack for", msg.Nonce)
+	} else {
+		s.send(&proto.FromSplitflap{
+			Payload: &proto.FromSplitflap_Ack{
+				Ack: &proto.Ack{Nonce: msg.Nonce},
+			},
+		})
+	}
+
+	now := time.Now()
+	switch msg.Payload.(type) {
+	case *proto.ToSplitflap_SplitflapCommand:
+		var started []int
+		for i, mc := range msg.GetSplitflapCommand().GetModules() {
+			if i >= len(s.modules) {
+				break
+			}
+			switch mc.GetAction() {
+			case proto.SplitflapCommand_ModuleCommand_GO_TO_FLAP:
+				if s.goTo(i, int(mc.GetParam()), false) {
+					started = append(started, i)
+				}
+			case proto.SplitflapCommand_ModuleCommand_RESET_AND_HOME:
+				s.reset(i)
+				started = append(started, i)
+			}
+		}
+		s.schedule(started, now)
+	case *proto.ToSplitflap_SplitflapConfig:
+		s.configure(msg.GetSplitflapConfig(), now)
+	case *proto.ToSplitflap_RequestState:
+		s.changed = true
+	}
+}
+
+// configure applies a SplitflapConfig message. The settings are always
+// applied; per-module configs move a module when its target or movement nonce
+// changes, and reset it when its reset nonce changes.
+func (s *simulator) configure(c *proto.SplitflapConfig, now time.Time) {
+	if st := c.GetSettings(); st != nil {
+		s.settings = &proto.Settings{
+			ForceFullRotation: st.GetForceFullRotation(),
+			MaxMoving:         st.GetMaxMoving(),
+			StartDelayMillis:  st.GetStartDelayMillis(),
+			AnimationStyle:    st.GetAnimationStyle(),
+		}
+		s.log("settings updated: %v", s.settings)
+	}
+
+	var started []int
+	for i, mc := range c.GetModules() {
+		if i >= len(s.modules) {
+			break
+		}
+		m := s.modules[i]
+		switch {
+		case mc.GetResetNonce() != m.resetNonce:
+			s.reset(i)
+			started = append(started, i)
+		case mc.GetMovementNonce() != m.movementNonce:
+			if s.goTo(i, int(mc.GetTargetFlapIndex()), true) {
+				started = append(started, i)
+			}
+		case int(mc.GetTargetFlapIndex()) != m.target:
+			if s.goTo(i, int(mc.GetTargetFlapIndex()), false) {
+				started = append(started, i)
+			}
+		}
+		m.movementNonce = mc.GetMovementNonce()
+		m.resetNonce = mc.GetResetNonce()
+	}
+	s.schedule(started, now)
+	s.changed = true
+}
+
+// goTo sets a new target for a module. It returns false if the module won't
+// move, either because it's in an error state or because it's already there.
+func (s *simulator) goTo(i, flap int, force bool) bool {
+	m := s.modules[i]
+	if m.state != proto.SplitflapState_ModuleState_NORMAL {
+		return false
+	}
+	m.target = flap % s.cfg.Flaps
+	m.homing = false
+	steps := (m.target - m.flap + s.cfg.Flaps) % s.cfg.Flaps
+	if m.moving {
+		m.steps = steps
+		return false
+	}
+	if steps == 0 {
+		if !force && !s.settings.GetForceFullRotation() {
+			return false
+		}
+		steps = s.cfg.Flaps
+	}
+	m.steps = steps
+	return true
+}
+
+// reset clears a module's error counters and starts it looking for its home
+// position.
+func (s *simulator) reset(i int) {
+	m := s.modules[i]
+	m.state = proto.SplitflapState_ModuleState_LOOK_FOR_HOME
+	m.countMissedHome = 0
+	m.countUnexpectedHome = 0
+	m.misses = 0
+	m.homing = true
+	m.target = 0
+	m.steps = s.cfg.Flaps - m.flap
+	s.changed = true
+}
+
+// schedule queues modules to start moving, in the order given by the current
+// animation style.
+func (s *simulator) schedule(modules []int, now time.Time) {
+	var ready []int
+	for _, i := range modules {
+		m := s.modules[i]
+		if !m.queued && !m.moving {
+			m.queued = true
+			ready = append(ready, i)
+		}
+	}
+	if len(ready) == 0 {
+		return
+	}
+	if len(s.pending) == 0 {
+		s.nextStart = now
+	}
+	s.pending = append(s.pending, s.animationOrder(ready)...)
+}
+
+// animationOrder splits modules into groups that start moving together,
+// ordered according to the animation style.
+func (s *simulator) animationOrder(modules []int) [][]int {
+	cols := s.cfg.Columns
+	row := func(i int) int { return i / cols }
+	col := func(i int) int { return i % cols }
+	// distance from the center of the row, in half-columns so that both
+	// middle columns of an even-width row are equally close.
+	center := func(i int) int {
+		d := 2*col(i) - (cols - 1)
+		if d < 0 {
+			d = -d
+		}
+		return d
+	}
+
+	var key func(i int) int
+	switch s.settings.GetAnimationStyle() {
+	case proto.Settings_RIGHT_TO_LEFT:
+		key = func(i int) int { return -i }
+	case proto.Settings_CENTER_OUT:
+		key = center
+	case proto.Settings_SIDES_IN:
+		key = func(i int) int { return -center(i) }
+	case proto.Settings_DOWN:
+		key = row
+	case proto.Settings_UP:
+		key = func(i int) int { return -row(i) }
+	default:
+		key = func(i int) int { return i }
+	}
+
+	sort.SliceStable(modules, func(a, b int) bool {
+		return key(modules[a]) < key(modules[b])
+	})
+	var groups [][]int
+	for n, i := range modules {
+		if n > 0 && key(i) == key(modules[n-1]) {
+			groups[len(groups)-1] = append(groups[len(groups)-1], i)
+			continue
+		}
+		groups = append(groups, []int{i})
+	}
+	return groups
+}
+
+// step advances the simulation by one flap time. Waiting modules are started,
+// subject to max_moving and start_delay_millis, and every moving module flips
+// one flap.
+func (s *simulator) step(now time.Time) {
+	// Start the modules that are waiting.
+	for len(s.pending) > 0 && !now.Before(s.nextStart) {
+		group := s.pending[0]
+		for len(group) > 0 {
+			if max := int(s.settings.GetMaxMoving()); max > 0 && s.movingCount() >= max {
+				break
+			}
+			m := s.modules[group[0]]
+			m.queued = false
+			m.moving = m.steps > 0
+			group = group[1:]
+		}
+		if len(group) > 0 {
+			s.pending[0] = group
+			break
+		}
+		s.pending = s.pending[1:]
+		s.nextStart = now.Add(time.Duration(s.settings.GetStartDelayMillis()) * time.Millisecond)
+	}
+
+	for i, m := range s.modules {
+		if !m.moving {
+			continue
+		}
+		s.changed = true
+		m.flap = (m.flap + 1) % s.cfg.Flaps
+		m.steps--
+		if m.flap == 0 {
+			s.passHome(i)
+		}
+		if m.steps <= 0 && m.moving {
+			m.moving = false
+			m.flap = m.target
+			if m.homing {
+				m.homing = false
+				m.state = proto.SplitflapState_ModuleState_NORMAL
+			}
+		}
+	}
+}
+
+// passHome is called when a module rotates past its home position. This is
+// where missed home faults are injected.
+func (s *simulator) passHome(i int) {
+	m := s.modules[i]
+	if !m.broken && s.rng.Float64() >= s.cfg.MissHome {
+		m.misses = 0
+		return
+	}
+
+	m.countMissedHome++
+	m.misses++
+	if m.misses >= maxMisses {
+		s.log("module %d: home not found, disabling", i)
+		m.state = proto.SplitflapState_ModuleState_SENSOR_ERROR
+		m.moving = false
+		m.homing = false
+		m.steps = 0
+		return
+	}
+	// Keep going around and look for home again.
+	s.log("module %d: missed home", i)
+	m.state = proto.SplitflapState_ModuleState_LOOK_FOR_HOME
+	m.homing = true
+	m.steps += s.cfg.Flaps
+}
+
+func (s *simulator) movingCount() int {
+	n := 0
+	for _, m := range s.modules {
+		if m.moving {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *simulator) sendState() {
+	state := &proto.SplitflapState{
+		Settings: s.settings,
+	}
+	for _, m := range s.modules {
+		state.Modules = append(state.Modules, &proto.SplitflapState_ModuleState{
+			State:               m.state,
+			FlapIndex:           uint32(m.flap),
+			Moving:              m.moving,
+			HomeState:           m.flap == 0 && !m.broken,
+			CountUnexpectedHome: m.countUnexpectedHome,
+			CountMissedHome:     m.countMissedHome,
+		})
+	}
+	s.send(&proto.FromSplitflap{
+		Payload: &proto.FromSplitflap_SplitflapState{
+			SplitflapState: state,
+		},
+	})
+}
+
+// sendSupervisorState reports on two simulated 12V power channels, each
+// feeding half of the modules. Current draw goes up with the number of
+// modules moving.
+func (s *simulator) sendSupervisorState() {
+	uptime := uint32(time.Since(s.booted).Milliseconds())
+	st := &proto.SupervisorState{
+		UptimeMillis: uptime,
+		State:        proto.SupervisorState_NORMAL,
+		FaultInfo: &proto.SupervisorState_FaultInfo{
+			Type: proto.SupervisorState_FaultInfo_NONE,
+		},
+	}
+
+	half := (len(s.modules) + 1) / 2
+	moving := [2]int{}
+	for i, m := range s.modules {
+		if m.moving {
+			moving[i/half]++
+		}
+	}
+	for _, n := range moving {
+		st.PowerChannels = append(st.PowerChannels, &proto.SupervisorState_PowerChannelState{
+			VoltageVolts: 12 + float32(s.rng.NormFloat64()*0.05),
+			CurrentAmps:  0.1 + 0.15*float32(n) + float32(s.rng.Float64()*0.01),
+			On:           true,
+		})
+	}
+
+	if s.cfg.SupervisorFault != "" {
+		ft, ok := proto.SupervisorState_FaultInfo_FaultType_value[s.cfg.SupervisorFault]
+		if !ok {
+			ft = int32(proto.SupervisorState_FaultInfo_UNKNOWN)
+		}
+		st.State = proto.SupervisorState_FAULT
+		st.FaultInfo = &proto.SupervisorState_FaultInfo{
+			Type:     proto.SupervisorState_FaultInfo_FaultType(ft),
+			Msg:      "simulated fault",
+			TsMillis: uptime,
+		}
+		for _, ch := range st.PowerChannels {
+			ch.On = false
+			ch.CurrentAmps = 0
+		}
+	}
+
+	s.send(&proto.FromSplitflap{
+		Payload: &proto.FromSplitflap_SupervisorState{
+			SupervisorState: st,
+		},
+	})
+}
+
+func (s *simulator) log(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	fmt.Println("log:", msg)
+	s.send(&proto.FromSplitflap{
+		Payload: &proto.FromSplitflap_Log{
+			Log: &proto.Log{Msg: msg},
+		},
+	})
+}
+
+// send frames a message and queues it for writing. If the client isn't
+// keeping up the frame is dropped rather than stalling the simulation, which
+// is roughly what happens to a real serial port.
+func (s *simulator) send(msg *proto.FromSplitflap) {
+	b, err := flapper.MarshalFrame(msg)
+	if err != nil {
+		fmt.Println("failed to encode message:", err)
+		return
+	}
+	if len(b) > 1 && s.rng.Float64() < s.cfg.Corrupt {
+		fmt.Println("corrupting frame")
+		b[s.rng.Intn(len(b)-1)] ^= 0x55
+	}
+	select {
+	case s.out <- b:
+	default:
+		fmt.Println("output backed up; dropping frame")
+	}
+}
+
+// writeFrames writes queued frames to the pty.
+func (s *simulator) writeFrames(w io.Writer) {
+	for b := range s.out {
+		if _, err := w.Write(b); err != nil {
+			fmt.Println("failed to write to pty:", err)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package flapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"unicode"
+
+	"github.com/trapgate/flapper/translit"
+)
+
+// DefaultFlaps is the character set of the standard splitflap module, in flap
+// order starting from the home flap.
+const DefaultFlaps = " abcdefghijklmnopqrstuvwxyz0123456789.,'"
+
+// unknownFlap is shown by Text for a module whose flap index is outside the
+// character set.
+const unknownFlap = '?'
+
+// Config describes the parts of a display that can't be learned from the
+// controller. It's normally loaded from a JSON file with LoadConfig.
+type Config struct {
+	// Flaps is the character on each flap, in order starting from the home
+	// flap. Any rune can be used, so color flaps can be given as, for
+	// example, emoji squares. If it's empty, DefaultFlaps is used.
+	Flaps string `json:"flaps,omitempty"`
+	// Modules is the number of modules the display is expected to have. If
+	// it's set, it's checked against the count reported by the controller.
+	Modules int `json:"modules,omitempty"`
+	// Rows and Columns give the layout of the modules. If only one is set,
+	// the other is worked out from the module count. If neither is, the
+	// display is laid out in rows of 12 when the module count allows it, and
+	// as a single row when it doesn't.
+	Rows    int `json:"rows,omitempty"`
+	Columns int `json:"columns,omitempty"`
+	// ModuleMap gives the position in the chain of each module, in layout
+	// order, for displays that aren't wired left to right and top to bottom.
+	// It must be a reordering of the first len(ModuleMap) modules; modules
+	// after those are left where they are.
+	ModuleMap []int `json:"module_map,omitempty"`
+	// DeadCells lists cells, in layout order, that text should be laid out
+	// around, for modules that are broken or missing.
+	DeadCells []int `json:"dead_cells,omitempty"`
+	// AvoidFaulty lays text out around modules that the controller reports
+	// as disabled or in an error state, as well as the DeadCells.
+	AvoidFaulty bool `json:"avoid_faulty,omitempty"`
+	// Calibration corrects individual modules, by cell in layout order.
+	Calibration map[int]Calibration `json:"calibration,omitempty"`
+	// Transliterate names the tables, from translit.Tables, used to write
+	// text in other scripts in Latin letters. If it's empty, all of them are
+	// used, and "none" turns transliteration off. Characters the display has
+	// flaps for are shown as they are.
+	Transliterate []string `json:"transliterate,omitempty"`
+	// Substitutions replace characters, or sequences of them, that the
+	// display can't show with text it can. They're added to
+	// DefaultSubstitutions, replacing any for the same text; map text to
+	// itself to turn a default off.
+	Substitutions map[string]string `json:"substitutions,omitempty"`
+}
+
+// Calibration corrects for a module that doesn't match the rest of the
+// display.
+type Calibration struct {
+	// Offset is how many flaps further on the module is than the controller
+	// thinks, for a module that homes on the wrong flap.
+	Offset int `json:"offset,omitempty"`
+	// Flaps, if set, is the module's character set in flap order, for a
+	// module whose flaps are in a different order from the rest. It must
+	// have as many flaps as the display's character set.
+	Flaps string `json:"flaps,omitempty"`
+}
+
+// flapSet is a character set, indexed both ways.
+type flapSet struct {
+	flaps []rune       // The character on each flap.
+	runes map[rune]int // The flap index of each character.
+}
+
+func newFlapSet(flaps string) (*flapSet, error) {
+	fs := &flapSet{flaps: []rune(flaps), runes: make(map[rune]int)}
+	for i, r := range fs.flaps {
+		if _, ok := fs.runes[r]; ok {
+			return nil, fmt.Errorf("flap character %q appears more than once", r)
+		}
+		fs.runes[r] = i
+	}
+	return fs, nil
+}
+
+// LoadConfig reads a display configuration from a JSON file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	err = json.Unmarshal(b, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// SaveConfig writes a display configuration to a JSON file.
+func SaveConfig(path string, cfg *Config) error {
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// SetConfig applies a configuration to the display. It returns an error if
+// the configuration doesn't match what the controller reported.
+func (d *Display) SetConfig(cfg *Config) error {
+	flaps := cfg.Flaps
+	if flaps == "" {
+		flaps = DefaultFlaps
+	}
+	fs, err := newFlapSet(flaps)
+	if err != nil {
+		return err
+	}
+	cellFlaps := make(map[int]*flapSet)
+	for cell, cal := range cfg.Calibration {
+		if cal.Flaps == "" {
+			continue
+		}
+		cfs, err := newFlapSet(cal.Flaps)
+		if err != nil {
+			return fmt.Errorf("cell %d: %w", cell, err)
+		}
+		if len(cfs.flaps) != len(fs.flaps) {
+			return fmt.Errorf("cell %d has %d flaps; the display has %d",
+				cell, len(cfs.flaps), len(fs.flaps))
+		}
+		cellFlaps[cell] = cfs
+	}
+	var tr *translit.Transliterator
+	if len(cfg.Transliterate) != 1 || cfg.Transliterate[0] != "none" {
+		tr, err = translit.New(cfg.Transliterate...)
+		if err != nil {
+			return err
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cfg.Modules != 0 && d.gotModules && cfg.Modules != d.cells {
+		return fmt.Errorf("configured for %d modules, but the controller reports %d",
+			cfg.Modules, d.cells)
+	}
+	modules := d.cells
+	if cfg.Modules != 0 && !d.gotModules {
+		modules = cfg.Modules
+	}
+	geom, err := cfg.geometry(modules)
+	if err != nil {
+		return err
+	}
+	toPhys, toLog, err := cfg.moduleMap(modules)
+	if err != nil {
+		return err
+	}
+	for _, c := range cfg.DeadCells {
+		if c < 0 || c >= modules {
+			return fmt.Errorf("dead cell %d out of range; the display has %d modules", c, modules)
+		}
+	}
+	for c := range cfg.Calibration {
+		if c < 0 || c >= modules {
+			return fmt.Errorf("calibrated cell %d out of range; the display has %d modules", c, modules)
+		}
+	}
+	d.cells = modules
+	d.geom = geom
+	d.toPhys = toPhys
+	d.toLog = toLog
+	d.cfg = *cfg
+	d.flaps = fs.flaps
+	d.runes = fs.runes
+	d.cellFlaps = cellFlaps
+	d.translit = tr
+	d.subst = newSubstituter(cfg.Substitutions)
+	return nil
+}
+
+// SetCalibration changes the calibration of one cell, given in layout order.
+func (d *Display) SetCalibration(cell int, cal Calibration) error {
+	cfg := d.Config()
+	// The map may be shared with a copy of the configuration returned
+	// earlier, so replace it rather than changing it.
+	m := make(map[int]Calibration)
+	for c, cc := range cfg.Calibration {
+		m[c] = cc
+	}
+	if cal == (Calibration{}) {
+		delete(m, cell)
+	} else {
+		m[cell] = cal
+	}
+	cfg.Calibration = m
+	return d.SetConfig(&cfg)
+}
+
+// Config returns the display's configuration.
+func (d *Display) Config() Config {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cfg
+}
+
+// Modules returns the number of modules in the display.
+func (d *Display) Modules() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cells
+}
+
+// Geometry returns the arrangement of the display's modules.
+func (d *Display) Geometry() Geometry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.geom
+}
+
+// Flaps returns the display's character set, in flap order.
+func (d *Display) Flaps() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return string(d.flaps)
+}
+
+// flapIndex returns the flap that cell must be sent to to show r, trying the
+// other case if r itself isn't in the character set. The cell's calibration
+// is taken into account.
+func (d *Display) flapIndex(cell int, r rune) (int, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	flaps, runes := d.flaps, d.runes
+	if c, ok := d.cellFlaps[cell]; ok {
+		flaps, runes = c.flaps, c.runes
+	}
+	i, ok := runes[r]
+	if !ok {
+		i, ok = runes[unicode.ToLower(r)]
+	}
+	if !ok {
+		i, ok = runes[unicode.ToUpper(r)]
+	}
+	if !ok {
+		return 0, false
+	}
+	return wrapFlap(i-d.cfg.Calibration[cell].Offset, len(flaps)), true
+}
+
+// flapRune returns the character shown by cell when the controller reports it
+// at flap i.
+func (d *Display) flapRune(cell int, i uint32) rune {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cellRune(cell, i)
+}
+
+// cellRune is like flapRune. d.mu must be held.
+func (d *Display) cellRune(cell int, i uint32) rune {
+	flaps := d.flaps
+	if c, ok := d.cellFlaps[cell]; ok {
+		flaps = c.flaps
+	}
+	if int(i) >= len(flaps) {
+		return unknownFlap
+	}
+	return flaps[wrapFlap(int(i)+d.cfg.Calibration[cell].Offset, len(flaps))]
+}
+
+// wrapFlap brings a flap index into the range 0 to n-1.
+func wrapFlap(i, n int) int {
+	i %= n
+	if i < 0 {
+		i += n
+	}
+	return i
+}
@@ -0,0 +1,209 @@
+package flapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/trapgate/flapper/proto"
+)
+
+const (
+	// When the connection to the controller is lost, reconnection attempts
+	// start at minReconnectDelay apart, doubling up to maxReconnectDelay.
+	minReconnectDelay = 250 * time.Millisecond
+	maxReconnectDelay = 10 * time.Second
+
+	// failAfter is the number of consecutive reconnection attempts that can
+	// fail before the connection is reported as Failed.
+	failAfter = 10
+)
+
+// ErrDisconnected is returned by commands sent while the connection to the
+// display has failed.
+var ErrDisconnected = errors.New("display disconnected")
+
+// Dialer opens a transport to the controller. It's called again each time the
+// connection needs to be reestablished.
+type Dialer func() (Transport, error)
+
+// ConnState describes the connection to the controller.
+type ConnState int
+
+const (
+	// Connected means the transport is open and working.
+	Connected ConnState = iota
+	// Reconnecting means the connection was lost, and the display is trying
+	// to reopen it.
+	Reconnecting
+	// Failed means the display has been unable to reconnect for a while, or
+	// has no way to reconnect at all. Commands fail with ErrDisconnected. If
+	// the display has a Dialer it keeps trying, and will return to Connected
+	// if it succeeds.
+	Failed
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Reconnecting:
+		return "reconnecting"
+	case Failed:
+		return "failed"
+	default:
+		return fmt.Sprintf("ConnState(%d)", int(s))
+	}
+}
+
+// ConnState returns the current state of the connection to the controller.
+func (d *Display) ConnState() ConnState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.connState
+}
+
+// Device returns the tty device the display is connected through, which may
+// change if the controller is reconnected. It's empty if the display was
+// created with a Transport or a Dialer.
+func (d *Display) Device() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dev
+}
+
+// OnConnStateChange registers f to be called whenever the connection state
+// changes. f is called from the goroutine that reads from the display, so it
+// must not block.
+func (d *Display) OnConnStateChange(f func(ConnState)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.connFuncs = append(d.connFuncs, f)
+}
+
+func (d *Display) setConnState(state ConnState) {
+	d.mu.Lock()
+	if d.connState == state {
+		d.mu.Unlock()
+		return
+	}
+	d.connState = state
+	funcs := append([]func(ConnState){}, d.connFuncs...)
+	d.mu.Unlock()
+
+	fmt.Println("display connection", state)
+	for _, f := range funcs {
+		f(state)
+	}
+}
+
+// readLoop reads frames from the display until it's closed, reconnecting
+// whenever the transport fails. fromDisplay is closed when it returns.
+func (d *Display) readLoop(fromDisplay chan<- *proto.FromSplitflap) {
+	defer close(fromDisplay)
+
+	for {
+		d.mu.Lock()
+		rw := d.rw
+		d.mu.Unlock()
+
+		err := d.readFrames(rw, fromDisplay)
+		if d.closed() {
+			return
+		}
+		fmt.Println("lost connection to display:", err)
+		if !d.reconnect() {
+			return
+		}
+		go d.restore()
+	}
+}
+
+// reconnect closes the broken transport and tries to open a new one, backing
+// off between attempts. It returns false if the display was closed, or can't
+// be reconnected.
+func (d *Display) reconnect() bool {
+	d.mu.Lock()
+	d.rw.Close()
+	dial := d.dial
+	d.mu.Unlock()
+
+	if dial == nil {
+		d.setConnState(Failed)
+		return false
+	}
+	d.setConnState(Reconnecting)
+
+	delay := minReconnectDelay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-time.After(delay):
+		case <-d.done:
+			return false
+		}
+
+		t, err := dial()
+		if err == nil {
+			d.mu.Lock()
+			d.rw = t
+			// The controller may have been reset, so where the modules
+			// were going is no longer known.
+			d.targets = nil
+			d.sent = nil
+			d.mu.Unlock()
+			d.setConnState(Connected)
+			return true
+		}
+		fmt.Printf("reconnect attempt %d failed: %v\n", attempt, err)
+		if attempt == failAfter {
+			d.setConnState(Failed)
+		}
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// restore puts the display back the way it was before the connection was
+// lost: the controller may have been reset, losing its settings and what it
+// was showing.
+func (d *Display) restore() {
+	ctx := context.Background()
+	d.mu.Lock()
+	d.targets = nil
+	d.sent = nil
+	settings := d.settings
+	wanted := make(map[int]rune)
+	for c, r := range d.wanted {
+		wanted[c] = r
+	}
+	d.mu.Unlock()
+
+	if err := d.readStatus(ctx); err != nil {
+		fmt.Println("failed to read status after reconnecting:", err)
+		return
+	}
+	if settings != nil {
+		if err := d.sendSettings(ctx, settings); err != nil {
+			fmt.Println("failed to restore settings:", err)
+		}
+	}
+	if len(wanted) > 0 {
+		// What the modules were showing, or were sent to, may have been
+		// lost, so send every cell.
+		if err := d.sendCells(ctx, wanted, &TextOptions{Full: true}); err != nil {
+			fmt.Println("failed to restore text:", err)
+		}
+	}
+}
+
+func (d *Display) closed() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
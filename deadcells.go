@@ -0,0 +1,74 @@
+package flapper
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/trapgate/flapper/proto"
+)
+
+// moduleFaulty reports whether a module is in a state that stops it showing
+// text.
+func moduleFaulty(m *proto.SplitflapState_ModuleState) bool {
+	switch m.GetState() {
+	case proto.SplitflapState_ModuleState_SENSOR_ERROR,
+		proto.SplitflapState_ModuleState_PANIC,
+		proto.SplitflapState_ModuleState_STATE_DISABLED:
+		return true
+	}
+	return false
+}
+
+// setFaulty records which modules the controller reports as faulty. d.mu must
+// be held.
+func (d *Display) setFaulty(st *proto.SplitflapState) {
+	d.faulty = d.faulty[:0]
+	for p, m := range st.GetModules() {
+		if moduleFaulty(m) {
+			d.faulty = append(d.faulty, p)
+		}
+	}
+}
+
+// SetDeadCells marks cells as unusable, in addition to any listed in the
+// configuration. Text is laid out around them, and they're left alone when
+// the text changes. Cells are numbered in layout order. Calling SetDeadCells
+// with no cells clears the list.
+func (d *Display) SetDeadCells(cells ...int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range cells {
+		if c < 0 || c >= d.cells {
+			return fmt.Errorf("cell %d out of range; the display has %d modules", c, d.cells)
+		}
+	}
+	d.deadCells = append([]int(nil), cells...)
+	return nil
+}
+
+// DeadCells returns the cells that text is currently laid out around, in
+// layout order. These are the cells marked with SetDeadCells or in the
+// configuration, and, if the configuration's AvoidFaulty is set, the cells
+// whose modules the controller reports as faulty.
+func (d *Display) DeadCells() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dead := make(map[int]bool)
+	for _, c := range d.cfg.DeadCells {
+		dead[c] = true
+	}
+	for _, c := range d.deadCells {
+		dead[c] = true
+	}
+	if d.cfg.AvoidFaulty {
+		for _, p := range d.faulty {
+			dead[d.logical(p)] = true
+		}
+	}
+	cells := make([]int, 0, len(dead))
+	for c := range dead {
+		cells = append(cells, c)
+	}
+	sort.Ints(cells)
+	return cells
+}
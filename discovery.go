@@ -0,0 +1,223 @@
+package flapper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/trapgate/flapper/proto"
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+const (
+	// probeTimeout is how long to wait for a candidate port to answer a
+	// RequestState before deciding it isn't a splitflap controller.
+	probeTimeout = 2 * time.Second
+)
+
+// ErrNoController is returned by Discover when none of the candidate ports
+// turned out to have a splitflap controller attached.
+var ErrNoController = errors.New("no splitflap controller found")
+
+// USBMatch identifies a USB serial device that a controller might be attached
+// through. VID and PID are hex strings, as reported by the OS; an empty field
+// matches anything.
+type USBMatch struct {
+	VID          string
+	PID          string
+	SerialNumber string
+}
+
+// DefaultUSBMatches covers the USB serial bridges found on the boards the
+// splitflap firmware runs on.
+var DefaultUSBMatches = []USBMatch{
+	{VID: "1a86", PID: "55d4"}, // CH9102, on newer TTGO T-Display boards
+	{VID: "1a86", PID: "7523"}, // CH340
+	{VID: "10c4", PID: "ea60"}, // CP210x, on older TTGO T-Display boards
+	{VID: "303a"},              // Espressif native USB
+	{VID: "2341"},              // Arduino
+}
+
+// ParseUSBMatch parses a match in the form VID:PID or VID:PID:SERIAL. Either
+// of VID or PID may be left empty, or given as *, to match anything.
+func ParseUSBMatch(s string) (USBMatch, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) < 2 {
+		return USBMatch{}, fmt.Errorf("invalid usb match %q: want VID:PID[:SERIAL]", s)
+	}
+	for i, p := range parts {
+		if p == "*" {
+			parts[i] = ""
+		}
+	}
+	m := USBMatch{VID: parts[0], PID: parts[1]}
+	if len(parts) == 3 {
+		m.SerialNumber = parts[2]
+	}
+	return m, nil
+}
+
+// Matches reports whether the USB port p matches m.
+func (m USBMatch) Matches(p *enumerator.PortDetails) bool {
+	if !p.IsUSB {
+		return false
+	}
+	if m.VID != "" && !strings.EqualFold(m.VID, p.VID) {
+		return false
+	}
+	if m.PID != "" && !strings.EqualFold(m.PID, p.PID) {
+		return false
+	}
+	if m.SerialNumber != "" && m.SerialNumber != p.SerialNumber {
+		return false
+	}
+	return true
+}
+
+// PortInfo describes a serial port found on the system.
+type PortInfo struct {
+	Name         string
+	IsUSB        bool
+	VID          string
+	PID          string
+	SerialNumber string
+	Product      string
+	Matched      bool // The port matched one of the USBMatches.
+}
+
+func (p PortInfo) String() string {
+	if !p.IsUSB {
+		return p.Name
+	}
+	s := fmt.Sprintf("%v %v:%v", p.Name, p.VID, p.PID)
+	if p.SerialNumber != "" {
+		s += " serial " + p.SerialNumber
+	}
+	if p.Product != "" {
+		s += " (" + p.Product + ")"
+	}
+	return s
+}
+
+// ListPorts returns every serial port on the system, marking the ones that
+// match any of matches. If matches is empty, DefaultUSBMatches is used.
+func ListPorts(matches []USBMatch) ([]PortInfo, error) {
+	if len(matches) == 0 {
+		matches = DefaultUSBMatches
+	}
+	ports, err := enumerator.GetDetailedPortsList()
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []PortInfo
+	for _, p := range ports {
+		info := PortInfo{
+			Name:         p.Name,
+			IsUSB:        p.IsUSB,
+			VID:          p.VID,
+			PID:          p.PID,
+			SerialNumber: p.SerialNumber,
+			Product:      p.Product,
+		}
+		for _, m := range matches {
+			if m.Matches(p) {
+				info.Matched = true
+				break
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Discover looks for a splitflap controller attached to one of the serial
+// ports matching matches, and returns the device path of the first one that
+// answers a RequestState. If matches is empty, DefaultUSBMatches is used.
+func Discover(matches []USBMatch) (string, error) {
+	ports, err := ListPorts(matches)
+	if err != nil {
+		return "", err
+	}
+
+	tried := 0
+	for _, p := range ports {
+		fmt.Println("found port", p)
+		if !p.Matched {
+			continue
+		}
+		tried++
+		err := Probe(p.Name)
+		if err != nil {
+			fmt.Printf("%v: %v\n", p.Name, err)
+			continue
+		}
+		fmt.Println("found splitflap controller on", p.Name)
+		return p.Name, nil
+	}
+
+	if tried == 0 {
+		return "", fmt.Errorf("%w: no matching usb devices among %d ports",
+			ErrNoController, len(ports))
+	}
+	return "", fmt.Errorf("%w: %d candidate ports did not respond",
+		ErrNoController, tried)
+}
+
+// Probe checks whether there's a splitflap controller on dev, by sending it a
+// RequestState and waiting for a valid frame to come back.
+func Probe(dev string) error {
+	p, err := connect(dev)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	return probe(p, probeTimeout)
+}
+
+func probe(p serial.Port, timeout time.Duration) error {
+	b, err := encodeMsg(&proto.ToSplitflap{
+		Nonce: 0,
+		Payload: &proto.ToSplitflap_RequestState{
+			RequestState: &proto.RequestState{},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := p.Write(b); err != nil {
+		return err
+	}
+
+	// Read with a short timeout so the deadline is checked regularly; a read
+	// that times out returns no bytes and no error.
+	err = p.SetReadTimeout(100 * time.Millisecond)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 256)
+	var frame []byte
+	for time.Now().Before(deadline) {
+		n, err := p.Read(buf)
+		if err != nil {
+			return err
+		}
+		for _, c := range buf[:n] {
+			frame = append(frame, c)
+			if c != 0 {
+				continue
+			}
+			// Anything that decodes is proof enough; there may be log
+			// messages or state updates in flight ahead of the reply.
+			if _, err := decodeMsg(frame); err == nil {
+				return nil
+			}
+			frame = frame[:0]
+		}
+	}
+	return errors.New("no response from controller")
+}
@@ -0,0 +1,138 @@
+package flapper
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trapgate/flapper/proto"
+	"go.bug.st/serial"
+	"go.bug.st/serial/enumerator"
+)
+
+func TestParseUSBMatch(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    USBMatch
+		wantErr bool
+	}{
+		{"1a86:7523", USBMatch{VID: "1a86", PID: "7523"}, false},
+		{"1a86:*", USBMatch{VID: "1a86"}, false},
+		{"*:7523", USBMatch{PID: "7523"}, false},
+		{":7523", USBMatch{PID: "7523"}, false},
+		{"1a86:", USBMatch{VID: "1a86"}, false},
+		{"1a86:7523:A1B2", USBMatch{VID: "1a86", PID: "7523", SerialNumber: "A1B2"}, false},
+		{"*:*:A1B2", USBMatch{SerialNumber: "A1B2"}, false},
+		{"1a86:7523:A:B", USBMatch{VID: "1a86", PID: "7523", SerialNumber: "A:B"}, false},
+		{"1a86", USBMatch{}, true},
+		{"", USBMatch{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseUSBMatch(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseUSBMatch(%q) = %+v, %v; want %+v, error %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestUSBMatchMatches(t *testing.T) {
+	ch340 := &enumerator.PortDetails{Name: "/dev/ttyUSB0", IsUSB: true, VID: "1A86", PID: "7523", SerialNumber: "A1B2"}
+	tests := []struct {
+		name string
+		m    USBMatch
+		p    *enumerator.PortDetails
+		want bool
+	}{
+		{"exact", USBMatch{VID: "1A86", PID: "7523"}, ch340, true},
+		{"case", USBMatch{VID: "1a86", PID: "7523"}, ch340, true},
+		{"any pid", USBMatch{VID: "1a86"}, ch340, true},
+		{"anything", USBMatch{}, ch340, true},
+		{"other vid", USBMatch{VID: "10c4"}, ch340, false},
+		{"other pid", USBMatch{VID: "1a86", PID: "55d4"}, ch340, false},
+		{"serial", USBMatch{SerialNumber: "A1B2"}, ch340, true},
+		{"serial is case sensitive", USBMatch{SerialNumber: "a1b2"}, ch340, false},
+		{"not usb", USBMatch{}, &enumerator.PortDetails{Name: "/dev/ttyS0"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.m.Matches(tt.p); got != tt.want {
+			t.Errorf("%v: %+v.Matches(%+v) = %v, want %v", tt.name, tt.m, tt.p, got, tt.want)
+		}
+	}
+}
+
+// fakePort is a serial port that answers with a fixed reply once something
+// is written to it. Methods probe doesn't use aren't implemented.
+type fakePort struct {
+	serial.Port
+
+	mu       sync.Mutex
+	reply    []byte
+	written  []byte
+	writeErr error
+	timeout  time.Duration
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.writeErr != nil {
+		return 0, p.writeErr
+	}
+	p.written = append(p.written, b...)
+	return len(b), nil
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	if len(p.written) == 0 || len(p.reply) == 0 {
+		timeout := p.timeout
+		p.mu.Unlock()
+		// Nothing to read; time out as a serial port does.
+		time.Sleep(timeout)
+		return 0, nil
+	}
+	defer p.mu.Unlock()
+	n := copy(b, p.reply)
+	p.reply = p.reply[n:]
+	return n, nil
+}
+
+func (p *fakePort) SetReadTimeout(t time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.timeout = t
+	return nil
+}
+
+func TestProbe(t *testing.T) {
+	state, err := MarshalFrame(&proto.FromSplitflap{
+		Payload: &proto.FromSplitflap_SplitflapState{SplitflapState: &proto.SplitflapState{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeErr := errors.New("write failed")
+	tests := []struct {
+		name    string
+		port    *fakePort
+		wantErr bool
+	}{
+		{"answers", &fakePort{reply: state}, false},
+		{"noise first", &fakePort{reply: append([]byte("boot messages\x00"), state...)}, false},
+		{"silent", &fakePort{}, true},
+		{"garbage", &fakePort{reply: []byte("not a frame\x00")}, true},
+		{"write fails", &fakePort{reply: state, writeErr: writeErr}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := probe(tt.port, 300*time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("probe = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.port.writeErr == nil && len(tt.port.written) == 0 {
+				t.Error("probe didn't send anything")
+			}
+		})
+	}
+}
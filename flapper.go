@@ -8,126 +8,275 @@ package flapper
 
 import (
 	"bufio"
-	"encoding/binary"
+	"context"
 	"errors"
 	"fmt"
-	"hash/crc32"
-	"io"
 	"math/rand"
 	"strings"
+	"sync"
 	"time"
-	"unicode"
 
 	"github.com/charmbracelet/lipgloss"
-	"github.com/dgryski/go-cobs"
-	"github.com/muesli/reflow/padding"
-	"github.com/muesli/reflow/wordwrap"
-	"github.com/muesli/reflow/wrap"
 	"github.com/trapgate/flapper/proto"
+	"github.com/trapgate/flapper/translit"
 	"go.bug.st/serial"
-	"golang.org/x/text/runes"
-	"golang.org/x/text/transform"
-	"golang.org/x/text/unicode/norm"
 	gproto "google.golang.org/protobuf/proto"
 )
 
 const (
-	retryTimeout = 250 * time.Millisecond
+	// defaultModules is the number of modules assumed if the controller
+	// doesn't report its state when the display is opened.
+	defaultModules = 24
+	// handshakeTimeout is how long to wait for the controller's first state
+	// report.
+	handshakeTimeout = 5 * time.Second
+	// ackBuffer is the number of acks that can be waiting for the sender.
+	ackBuffer = 16
+)
 
-	// TODO: Get this from the display
-	runeSet = " abcdefghijklmnopqrstuvwxyz0123456789.,'"
+var (
+	// ErrNotAcknowledged is returned when the display never acked a command,
+	// even after it was retransmitted as many times as the RetryPolicy allows.
+	ErrNotAcknowledged = errors.New("command not acknowledged by display")
+	// ErrClosed is returned by commands sent after the display was closed.
+	ErrClosed = errors.New("display closed")
 )
 
+// RetryPolicy controls how commands are retransmitted when the display doesn't
+// acknowledge them.
+type RetryPolicy struct {
+	// Timeout is how long to wait for an ack before the first retransmission.
+	// If it's zero, DefaultRetryPolicy.Timeout is used.
+	Timeout time.Duration
+	// Backoff multiplies the timeout after each retransmission. Values of 1 or
+	// less keep the timeout constant.
+	Backoff float64
+	// MaxTimeout caps the timeout as it backs off.
+	MaxTimeout time.Duration
+	// MaxRetries is the number of retransmissions before giving up with
+	// ErrNotAcknowledged. Zero means retry until the context is done.
+	MaxRetries int
+}
+
+// DefaultRetryPolicy gives up on a command after about 15 seconds.
+var DefaultRetryPolicy = RetryPolicy{
+	Timeout:    250 * time.Millisecond,
+	Backoff:    2,
+	MaxTimeout: 2 * time.Second,
+	MaxRetries: 9,
+}
+
 type sendReq struct {
-	msg *proto.ToSplitflap
-	ch  chan<- error
+	ctx   context.Context
+	msg   *proto.ToSplitflap
+	ch    chan<- error
+	onAck func() // Called by the reader when the ack arrives, if set.
 }
 
 // Display represents one or more splitflap units connected to a controller.
 type Display struct {
-	dev       string      // The tty device used to talk to the display
-	nonce     uint32      // nonce is incremented every time we send a pb
-	port      serial.Port // The serial device.
-	rw        io.ReadWriteCloser
+	nonce     uint32 // nonce is incremented every time we send a pb
 	toDisplay chan sendReq
-
-	text       string               // The text being displayed
-	cells      int                  // The number of units in the display
-	lastStatus proto.SplitflapState // The most recent status report from the display.
-	runes      map[rune]int
+	done      chan struct{} // Closed when the display is closed.
+
+	mu        sync.Mutex
+	dev       string    // The tty device used to talk to the display
+	rw        Transport // The serial device, or whatever stands in for it.
+	dial      Dialer    // Used to reopen the transport; nil if we can't.
+	connState ConnState
+	connFuncs []func(ConnState)
+	subs      map[*Subscription]struct{}
+	retry     RetryPolicy
+	wanted    map[int]rune    // What each cell was last set to show
+	settings  *proto.Settings // The settings most recently sent, if any
+	targets   []moduleTarget  // Where each module is or is going to, for diffing
+	sent      []int           // The flap each module was last sent to, or -1
+	pending   *sendReq        // The message waiting for an ack, if any.
+	logs      logRing         // Log messages from the firmware
+	logCap    int
+
+	supervisor *proto.SupervisorState // The most recent supervisor report.
+	faultFuncs []func(*proto.SupervisorState)
+
+	cfg        Config
+	gotState   chan struct{}    // Closed when the first state report arrives.
+	gotModules bool             // cells came from the controller.
+	flaps      []rune           // The character on each flap.
+	runes      map[rune]int     // The flap index of each character.
+	cellFlaps  map[int]*flapSet // Character sets of cells that differ.
+	translit   *translit.Transliterator
+	subst      *substituter
+	pipeline   Transformer // Prepares text for layout; nil for DefaultPipeline.
+	geom       Geometry
+	toPhys     []int // Chain positions by logical module index.
+	toLog      []int // Logical module indexes by chain position.
+	deadCells  []int // Cells marked unusable with SetDeadCells.
+	faulty     []int // Modules the controller reports as faulty.
+
+	text       string                // The text being displayed
+	cells      int                   // The number of units in the display
+	lastStatus *proto.SplitflapState // The most recent status report from the display.
 }
 
 // NewDisplay returns a new Display struct, representing a splitflap display
-// with one or more modules.
+// with one or more modules. The controller is found by searching the USB
+// serial devices for one that answers like a splitflap controller.
 func NewDisplay() (*Display, error) {
-	d := &Display{
-		// This is the device used for the TTGO.
-		dev:        "/dev/ttyACM0",
-		nonce:      rand.Uint32(),
-		toDisplay:  make(chan sendReq),
-		cells:      24, // TODO: Get this from the display
-		lastStatus: proto.SplitflapState{Settings: &proto.Settings{}},
-		runes:      make(map[rune]int),
+	return NewDisplayMatching(DefaultUSBMatches)
+}
+
+// NewDisplayMatching is like NewDisplay, but only considers USB devices that
+// match one of matches. If the connection is lost and the device doesn't
+// come back under the same name, the search is repeated.
+func NewDisplayMatching(matches []USBMatch) (*Display, error) {
+	dev, err := Discover(matches)
+	if err != nil {
+		return nil, err
+	}
+	return newDisplayOnDevice(dev, matches)
+}
+
+// NewDisplayOnDevice is like NewDisplay, but connects to the controller using
+// the given tty device. This can be a pty created by flapsim.
+func NewDisplayOnDevice(dev string) (*Display, error) {
+	return newDisplayOnDevice(dev, nil)
+}
+
+func newDisplayOnDevice(dev string, matches []USBMatch) (*Display, error) {
+	d := newDisplay()
+	d.dev = dev
+	d.dial = func() (Transport, error) {
+		d.mu.Lock()
+		dev := d.dev
+		d.mu.Unlock()
+		p, err := connect(dev)
+		if err == nil || len(matches) == 0 {
+			return p, err
+		}
+		// The device may have been renumbered when it came back.
+		dev, err = Discover(matches)
+		if err != nil {
+			return nil, err
+		}
+		d.mu.Lock()
+		d.dev = dev
+		d.mu.Unlock()
+		return connect(dev)
 	}
 
 	fmt.Println("connecting to display")
-	err := d.connect()
+	t, err := d.dial()
 	if err != nil {
 		return nil, err
 	}
+	return d.start(t), nil
+}
+
+// NewDisplayWithTransport returns a new Display that talks to its controller
+// over t instead of opening the serial port itself. The Display takes
+// ownership of t, and will close it when the Display is closed. Since the
+// Display can't reopen t, losing the connection is permanent.
+func NewDisplayWithTransport(t Transport) (*Display, error) {
+	return newDisplay().start(t), nil
+}
+
+// NewDisplayWithDialer returns a new Display that uses dial to open its
+// transport, both initially and whenever the connection is lost.
+func NewDisplayWithDialer(dial Dialer) (*Display, error) {
+	d := newDisplay()
+	d.dial = dial
+	t, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return d.start(t), nil
+}
+
+func newDisplay() *Display {
+	d := &Display{
+		nonce:      rand.Uint32(),
+		toDisplay:  make(chan sendReq),
+		done:       make(chan struct{}),
+		retry:      DefaultRetryPolicy,
+		subs:       make(map[*Subscription]struct{}),
+		logCap:     defaultLogCapacity,
+		gotState:   make(chan struct{}),
+		cells:      defaultModules,
+		lastStatus: &proto.SplitflapState{Settings: &proto.Settings{}},
+	}
+	d.SetConfig(&Config{})
+	return d
+}
+
+// start begins talking to the display over t.
+func (d *Display) start(t Transport) *Display {
+	d.rw = t
 
 	// Start the goroutine that will read frames send back from the display
 	fmt.Println("starting display goroutine")
 	go d.communicate(d.toDisplay)
 
-	// TODO: Wait for the result.
-	d.readStatus()
-	for i, r := range runeSet {
-		d.runes[r] = i
+	// The first state report tells us how many modules there are.
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	err := d.readStatus(ctx)
+	if err == nil {
+		select {
+		case <-d.gotState:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if err != nil {
+		fmt.Printf("display did not report its state (%v); assuming %d modules\n",
+			err, defaultModules)
 	}
 
-	return d, err
+	return d
 }
 
-func (d *Display) connect() error {
+// connect opens the serial device the controller is attached to.
+func connect(dev string) (serial.Port, error) {
 	// The Arduino used 38400; the baud rate of the TTGO TDisplay is 230400.
 	mode := &serial.Mode{BaudRate: 230400}
-	p, err := serial.Open(d.dev, mode)
-	if err != nil {
-		return err
-	}
-	d.port = p
-	d.rw = p
-
-	return err
+	return serial.Open(dev, mode)
 }
 
 // Close will close the serial port and stop the comms goroutine.
 func (d *Display) Close() {
+	close(d.done)
+	d.mu.Lock()
 	d.rw.Close()
-	close(d.toDisplay)
+	d.mu.Unlock()
 }
 
-// HardReset will reset the whole microcontroller.
-func (d *Display) HardReset() {
-	d.port.SetRTS(true)
-	d.port.SetDTR(false)
+// HardReset will reset the whole microcontroller. It returns ErrNoLineControl
+// if the transport has no RTS and DTR lines to toggle.
+func (d *Display) HardReset() error {
+	d.mu.Lock()
+	lc, ok := d.rw.(LineController)
+	d.mu.Unlock()
+	if !ok {
+		return ErrNoLineControl
+	}
+	lc.SetRTS(true)
+	lc.SetDTR(false)
 	time.Sleep(200 * time.Millisecond)
-	d.port.SetDTR(true)
+	lc.SetDTR(true)
 	time.Sleep(200 * time.Millisecond)
+	return nil
 }
 
-// readFrames will read bytes from the serial port, assemble them into a frame,
+// readFrames will read bytes from the transport, assemble them into a frame,
 // decode it, and send the resulting protobuf message to the fromDisplay
-// channel. This should be run in a goroutine.
-// TODO: Handle shutdown cleanly.
-func (d *Display) readFrames(fromDisplay chan<- *proto.FromSplitflap) {
-	rdr := bufio.NewReader(d.rw)
+// channel. It returns when reading fails, or when the display is closed.
+func (d *Display) readFrames(rw Transport, fromDisplay chan<- *proto.FromSplitflap) error {
+	rdr := bufio.NewReader(rw)
 
 	for {
 		b, err := rdr.ReadBytes(0)
 		if err != nil {
-			panic("failed to read from display")
+			return err
 		}
 
 		msg, err := decodeMsg(b)
@@ -137,33 +286,19 @@ func (d *Display) readFrames(fromDisplay chan<- *proto.FromSplitflap) {
 		}
 
 		// send the decode message to anyone who might be listening.
-		fromDisplay <- msg
+		select {
+		case fromDisplay <- msg:
+		case <-d.done:
+			return nil
+		}
 	}
 }
 
 func decodeMsg(b []byte) (*proto.FromSplitflap, error) {
-	// Frames include a 4-byte crc and a terminating null, or else discard them.
-	if len(b) < 5 {
-		// Empty frame. Just keep trying.
-		return nil, errors.New("empty frame")
-	}
-	// decode the buffer. Don't include the zero byte.
-	b, err := cobs.Decode(b[:len(b)-1])
-	if err != nil {
-		return nil, errors.New("failed to decode cobs frame")
-	}
-
-	crcBytes := b[len(b)-4:]
-	b = b[:len(b)-4]
-	crc := binary.LittleEndian.Uint32(crcBytes)
-	if crc32.ChecksumIEEE(b) != crc {
-		return nil, errors.New("bad crc")
-	}
-
 	msg := &proto.FromSplitflap{}
-	err = gproto.Unmarshal(b, msg)
+	err := UnmarshalFrame(b, msg)
 	if err != nil {
-		return nil, errors.New("failed to unmarshal protobuf message")
+		return nil, err
 	}
 	return msg, nil
 }
@@ -174,7 +309,10 @@ func (d *Display) write(msg *proto.ToSplitflap) error {
 	if err != nil {
 		return err
 	}
-	_, err = d.rw.Write(b)
+	d.mu.Lock()
+	rw := d.rw
+	d.mu.Unlock()
+	_, err = rw.Write(b)
 	if err != nil {
 		return err
 	}
@@ -183,35 +321,24 @@ func (d *Display) write(msg *proto.ToSplitflap) error {
 }
 
 func encodeMsg(msg *proto.ToSplitflap) ([]byte, error) {
-	b, err := gproto.Marshal(msg)
-	if err != nil {
-		return nil, err
-	}
-
-	// Append the crc32 value to the end of the payload before sending it.
-	crc := crc32.ChecksumIEEE(b)
-	crcBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(crcBytes, crc)
-	b = append(b, crcBytes...)
-
-	// cobs is used to encode the buffer with no zero bytes.
-	cb := append(cobs.Encode(b), byte(0))
-	return cb, nil
+	return MarshalFrame(msg)
 }
 
 func (d *Display) communicate(toDisplay <-chan sendReq) {
 	fromDisplay := make(chan *proto.FromSplitflap)
-	acks := make(chan uint32)
+	// Acks are buffered so the reader never waits for the sender: an ack can
+	// arrive before the sender starts waiting for it, or after it's given up.
+	acks := make(chan uint32, ackBuffer)
 
 	// Incoming frames from the display are read by this goroutine.
-	go d.readFrames(fromDisplay)
+	go d.readLoop(fromDisplay)
 	go d.writeMsgs(toDisplay, acks)
 
 	for msg := range fromDisplay {
 		d.handleFromMsg(msg, acks)
-		// TODO: Send this message to anyone who has registered for it.
-		// TODO: Handle shutdown
+		d.publish(msg)
 	}
+	d.closeSubs()
 }
 
 func (d *Display) nextNonce() uint32 {
@@ -224,64 +351,212 @@ func (d *Display) writeMsgs(toDisplay <-chan sendReq, acks <-chan uint32) {
 	rand.Seed(time.Now().UnixMicro())
 	nonce := d.nextNonce()
 
-	for req := range toDisplay {
+	for {
+		var req sendReq
+		select {
+		case req = <-toDisplay:
+		case <-d.done:
+			return
+		}
+
 		req.msg.Nonce = nonce
+		d.mu.Lock()
+		d.pending = &req
+		d.mu.Unlock()
+		err := d.transmit(req, acks)
+		d.mu.Lock()
+		d.pending = nil
+		d.mu.Unlock()
+		req.ch <- err
+		nonce = d.nextNonce()
+	}
+}
 
-		for acked := false; !acked; {
-			fmt.Println("sending", req.msg)
-			err := d.write(req.msg)
-			if err != nil {
-				req.ch <- err
-			}
+// transmit writes a message to the display, and retransmits it until it's
+// acked, the retry policy gives up, or the request's context is done.
+func (d *Display) transmit(req sendReq, acks <-chan uint32) error {
+	policy := d.RetryPolicy()
+	timeout := policy.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRetryPolicy.Timeout
+	}
+
+	// Throw away acks for earlier messages, which may have arrived after
+	// they were given up on.
+	for len(acks) > 0 {
+		<-acks
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := req.ctx.Err(); err != nil {
+			return err
+		}
+		if d.ConnState() == Failed {
+			return ErrDisconnected
+		}
+		fmt.Println("sending", req.msg)
+		err := d.write(req.msg)
+		if err != nil {
+			// The link is probably down. Keep trying; the message will go
+			// out once the display has been reconnected.
+			fmt.Println("send failed:", err)
+		}
 
-			timer := time.NewTimer(retryTimeout)
+		timer := time.NewTimer(timeout)
+	wait:
+		for {
 			select {
 			case ackNonce := <-acks:
-				if ackNonce == nonce {
-					acked = true
-					req.ch <- nil
-					if !timer.Stop() {
-						<-timer.C
-					}
+				if ackNonce == req.msg.Nonce {
+					timer.Stop()
+					return nil
 				}
+				// A late ack for an earlier message; keep waiting.
 			case <-timer.C:
-				fmt.Println("send timed out; resending")
-				// Nothing to do here; we'll loop and retry
+				break wait
+			case <-req.ctx.Done():
+				timer.Stop()
+				return req.ctx.Err()
+			case <-d.done:
+				timer.Stop()
+				return ErrClosed
 			}
 		}
 
-		nonce = d.nextNonce()
+		if policy.MaxRetries > 0 && attempt > policy.MaxRetries {
+			return fmt.Errorf("%w after %d attempts", ErrNotAcknowledged, attempt)
+		}
+		fmt.Println("send timed out; resending")
+		if policy.Backoff > 1 {
+			timeout = time.Duration(float64(timeout) * policy.Backoff)
+		}
+		if policy.MaxTimeout > 0 && timeout > policy.MaxTimeout {
+			timeout = policy.MaxTimeout
+		}
 	}
 }
 
+// send queues a message for the display and waits until it's acked.
+func (d *Display) send(ctx context.Context, msg *proto.ToSplitflap) error {
+	return d.sendAcked(ctx, msg, nil)
+}
+
+// sendAcked is like send, but onAck, if it isn't nil, is called as soon as
+// the ack arrives, before any messages from the display that follow it are
+// handled.
+func (d *Display) sendAcked(ctx context.Context, msg *proto.ToSplitflap, onAck func()) error {
+	ch := make(chan error, 1)
+	req := sendReq{
+		ctx:   ctx,
+		msg:   msg,
+		ch:    ch,
+		onAck: onAck,
+	}
+
+	select {
+	case d.toDisplay <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-d.done:
+		return ErrClosed
+	}
+
+	// The send loop gives up on the request when ctx is done, so it will
+	// always answer.
+	return <-ch
+}
+
+// RetryPolicy returns the policy used to retransmit unacknowledged commands.
+func (d *Display) RetryPolicy() RetryPolicy {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.retry
+}
+
+// SetRetryPolicy changes how unacknowledged commands are retransmitted. It
+// takes effect from the next command sent.
+func (d *Display) SetRetryPolicy(p RetryPolicy) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.retry = p
+}
+
 func (d *Display) handleFromMsg(msg *proto.FromSplitflap, acks chan<- uint32) {
 	switch msg.Payload.(type) {
 	case *proto.FromSplitflap_SplitflapState:
-		d.lastStatus = *msg.GetSplitflapState()
+		d.mu.Lock()
+		// The message is shared with subscribers, so keep a copy of it.
+		d.lastStatus = gproto.Clone(msg.GetSplitflapState()).(*proto.SplitflapState)
+		// The geometry and module map are checked against the module count
+		// whenever it changes, in case the controller was reflashed or
+		// swapped for another.
+		resized := !d.gotModules || d.cells != len(d.lastStatus.Modules)
 		d.cells = len(d.lastStatus.Modules)
-		d.text = currentText(&d.lastStatus)
-		// dumpStateMsg(&d.lastStatus)
+		d.setFaulty(d.lastStatus)
+		d.checkTargets(d.lastStatus)
+		if !d.gotModules {
+			d.gotModules = true
+			close(d.gotState)
+		}
+		if resized {
+			if d.cfg.Modules != 0 && d.cfg.Modules != d.cells {
+				fmt.Printf("display configured for %d modules, but the controller reports %d\n",
+					d.cfg.Modules, d.cells)
+			}
+			d.fitConfig()
+		}
+		d.text = d.currentText(d.lastStatus)
+		d.mu.Unlock()
+		// d.dumpStateMsg(d.lastStatus)
 	case *proto.FromSplitflap_Log:
-		// For now just print them.
-		fmt.Println(msg.GetLog().Msg)
+		d.addLog(msg.GetLog().Msg)
 	case *proto.FromSplitflap_Ack:
 		fmt.Printf("received ack for %v\n", msg.GetAck().GetNonce())
-		acks <- msg.GetAck().GetNonce()
+		var onAck func()
+		d.mu.Lock()
+		if d.pending != nil && d.pending.msg.GetNonce() == msg.GetAck().GetNonce() {
+			onAck = d.pending.onAck
+		}
+		d.mu.Unlock()
+		if onAck != nil {
+			onAck()
+		}
+		select {
+		case acks <- msg.GetAck().GetNonce():
+		default:
+			// Nothing has read the acks for a while, so they're all stale.
+			fmt.Println("dropped ack; no command is waiting for it")
+		}
+	case *proto.FromSplitflap_SupervisorState:
+		d.handleSupervisorState(msg.GetSupervisorState())
 	default:
 		fmt.Println("received", msg)
 	}
 }
 
-func currentText(msg *proto.SplitflapState) string {
+// currentText returns the text shown by the modules in msg, with a line for
+// each row of the display. d.mu must be held.
+func (d *Display) currentText(msg *proto.SplitflapState) string {
+	g := d.geom
+	modules := d.inLogicalOrder(msg.Modules)
 	text := strings.Builder{}
-	for _, m := range msg.Modules {
-		text.WriteByte(runeSet[m.FlapIndex])
+	for row := 0; row < g.Rows; row++ {
+		if row > 0 {
+			text.WriteRune('\n')
+		}
+		for col := 0; col < g.Columns; col++ {
+			r := ' '
+			if i := g.Module(row, col); i < len(modules) {
+				r = d.cellRune(i, modules[i].FlapIndex)
+			}
+			text.WriteRune(r)
+		}
 	}
 	return text.String()
 }
 
 // dumpStateMsg displays a SplitflapState message to the terminal, using color.
-func dumpStateMsg(msg *proto.SplitflapState) {
+func (d *Display) dumpStateMsg(msg *proto.SplitflapState) {
 	// Settings first
 	off := lipgloss.NewStyle().Foreground(lipgloss.Color("#C0C0C0"))
 	on := lipgloss.NewStyle().Foreground(lipgloss.Color("#10D000"))
@@ -302,19 +577,21 @@ func dumpStateMsg(msg *proto.SplitflapState) {
 	normal := lipgloss.NewStyle().Foreground(lipgloss.Color("#00E010"))
 	er := lipgloss.NewStyle().Foreground(lipgloss.Color("#F00010"))
 
-	for i, m := range msg.Modules {
-		if i == 12 {
+	cols := d.Geometry().Columns
+	modules := d.logicalOrder(msg.Modules)
+	for i, m := range modules {
+		if i > 0 && cols > 0 && i%cols == 0 {
 			fmt.Println()
 		}
 		style := &stopped
-		char := runeSet[m.FlapIndex]
+		char := d.flapRune(i, m.FlapIndex)
 		if m.Moving {
 			style = &moving
 		}
 		fmt.Print(style.Render(string(char)))
 	}
 	fmt.Println()
-	for i, m := range msg.Modules {
+	for i, m := range modules {
 		style := &normal
 		if m.State != proto.SplitflapState_ModuleState_NORMAL {
 			style = &er
@@ -334,114 +611,170 @@ func dumpStateMsg(msg *proto.SplitflapState) {
 
 // Init requests the display state, and should be called after connecting.
 func (d *Display) Init() error {
+	return d.InitContext(context.Background())
+}
+
+// InitContext is like Init, but gives up when ctx is done.
+func (d *Display) InitContext(ctx context.Context) error {
 	fmt.Println("init display")
-	ch := make(chan error)
-	req := sendReq{
-		msg: &proto.ToSplitflap{
-			Payload: &proto.ToSplitflap_RequestState{
-				RequestState: &proto.RequestState{},
-			},
+	return d.send(ctx, &proto.ToSplitflap{
+		Payload: &proto.ToSplitflap_RequestState{
+			RequestState: &proto.RequestState{},
 		},
-		ch: ch,
-	}
-	d.toDisplay <- req
-	return <-ch
+	})
 }
 
 // SetText will display the passed string on the splitflaps. If the string is
 // shorter than the available cells on the display it will be padded with
-// spaces; if it's longer it will be truncated mercilessly.
-// TODO: validate each character - don't pass runes the display can't display.
+// spaces; if it's longer it will be truncated mercilessly. Characters the
+// display can't show are left blank. Use ShowTextOpts to find out what didn't
+// fit, or to reject such text.
 func (d *Display) SetText(text string) error {
-	text = d.PrepText(text)
-	ch := make(chan error)
-
-	fmt.Println(text)
-	mc := make([]*proto.SplitflapCommand_ModuleCommand, d.cells)
-	for i, r := range text {
-		mc[i] = &proto.SplitflapCommand_ModuleCommand{
-			Action: proto.SplitflapCommand_ModuleCommand_GO_TO_FLAP,
-			Param:  uint32(d.runes[r]),
+	return d.SetTextContext(context.Background(), text)
+}
+
+// SetTextContext is like SetText, but gives up when ctx is done.
+func (d *Display) SetTextContext(ctx context.Context, text string) error {
+	_, err := d.ShowText(ctx, text)
+	return err
+}
+
+// ShowText is like SetTextContext, but also returns how the text was laid
+// out. Dead cells are left alone, and the text is laid out around them.
+func (d *Display) ShowText(ctx context.Context, text string) (*Layout, error) {
+	return d.ShowTextOpts(ctx, text, nil)
+}
+
+// ShowTextOpts is like ShowText, but opts can change which cells are moved,
+// and ask for text that doesn't fit to be rejected.
+func (d *Display) ShowTextOpts(ctx context.Context, text string, opts *TextOptions) (*Layout, error) {
+	layout := d.LayoutTextOpts(text, opts)
+	if opts != nil && opts.Strict && !layout.Fits() {
+		return layout, &FitError{Layout: layout}
+	}
+	return d.showLayout(ctx, layout, opts)
+}
+
+// showLayout sends the text of a layout to the display, leaving the cells it
+// skipped alone.
+func (d *Display) showLayout(ctx context.Context, layout *Layout, opts *TextOptions) (*Layout, error) {
+	fmt.Println(layout.Text)
+	g := d.Geometry()
+	want := make(map[int]rune)
+	for row, line := range strings.Split(layout.Text, "\n") {
+		for col, r := range []rune(line) {
+			if row < g.Rows && col < g.Columns {
+				want[g.Module(row, col)] = r
+			}
 		}
 	}
-	req := sendReq{
-		msg: &proto.ToSplitflap{
-			Payload: &proto.ToSplitflap_SplitflapCommand{
-				SplitflapCommand: &proto.SplitflapCommand{
-					Modules: mc,
-				},
-			},
-		},
-		ch: ch,
+	for _, c := range layout.Skipped {
+		delete(want, c)
 	}
+	err := d.sendCells(ctx, want, opts)
+	if err != nil {
+		return nil, err
+	}
+	return layout, nil
+}
 
-	d.toDisplay <- req
-
-	return <-ch
+// LayoutTextOpts is like LayoutText, but prepares and formats the text as
+// opts says, as ShowTextOpts would.
+func (d *Display) LayoutTextOpts(text string, opts *TextOptions) *Layout {
+	m := &Message{Text: text, Display: d}
+	if opts == nil {
+		return d.layoutMessage(m, nil)
+	}
+	m.Format = opts.Format
+	return d.layoutMessage(m, opts.Transform)
 }
 
+// PrepText lays text out for the display. It returns a line for each row,
+// each padded or cut to the width of the display.
 func (d *Display) PrepText(text string) string {
+	g := d.Geometry()
+	lines, _, _ := d.prepLines(text, g.Columns, g.Rows, nil)
+	return strings.Join(lines, "\n")
+}
+
+// prepLines lays text out in a box of width columns and height rows. It also
+// returns the number of lines of text, split at newlines, that were dropped
+// completely because there was no room for them, and the number of hyphens
+// added to break long words.
+func (d *Display) prepLines(text string, width, height int, f *Format) ([]string, int, int) {
+	var hyph rune
+	if f != nil && f.Hyphenate {
+		hyph = d.hyphenMark()
+	}
 	// First, normalize the text so that it only has characters the display can
 	// show.
-	text = d.normalize(text)
-	// This works imperfectly. It does accomplish forcing a line break when the
-	// top word is longer than 12 runes, but when it does so the second line
-	// doesn't get appended to the broken first. Also, it's not possible to
-	// indent line 2, because the word wrap consumes all the spaces.
-	text = wrap.String(wordwrap.String(text, 12), 12)
-	lines := strings.SplitN(text, "\n", 3)
-	// fmt.Printf("%q %q\n", lines[0], lines[1])
-	if len(lines) < 2 {
-		lines = append(lines, " ")
-	}
-	for i, line := range lines[:2] {
-		// If leading spaces are used to push the word to line 2, line 1 will be
-		// empty and the padding routine will refuse to pad it out to 12. So...
-		if len(line) == 0 {
-			line = " "
+	return layoutBox(d.normalize(text), width, height, f, hyph)
+}
+
+// hyphenMark returns the flap to end a row with when a word is broken
+// across rows, or 0 if no module has one.
+func (d *Display) hyphenMark() rune {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+marks:
+	for _, r := range hyphenMarks {
+		if _, ok := d.runes[r]; !ok {
+			continue
 		}
-		// Make the text fit the display exactly.
-		line = padding.String(line, 12)
-		if len(line) > 12 {
-			line = line[:12]
+		for _, c := range d.cellFlaps {
+			if _, ok := c.runes[r]; !ok {
+				continue marks
+			}
 		}
-		lines[i] = line
+		return r
 	}
-	//d.text = strings.Join(lines[:2], "")
-	// fmt.Printf("%q: %q %q", text, lines[0], lines[1])
-
-	return strings.Join(lines[:2], "")
+	return 0
 }
 
-// normalize will convert all runes to their closest ascii equivalents
+// normalize will convert all runes to their closest ascii equivalents, first
+// substituting or transliterating those that the display can't show.
 func (d *Display) normalize(s string) string {
-	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
-	s, _, _ = transform.String(t, s)
-	s = strings.ToLower(s)
+	s, _ = d.normalizeText(s)
 	return s
 }
 
-func (d *Display) readStatus() error {
-	ch := make(chan error)
-	req := sendReq{
-		msg: &proto.ToSplitflap{
-			Payload: &proto.ToSplitflap_RequestState{},
-		},
-		ch: ch,
-	}
-
-	d.toDisplay <- req
+// normalizeText is like normalize, but also returns the substitutions made.
+func (d *Display) normalizeText(s string) (string, []Substitution) {
+	m := d.Transform(s, nil)
+	return m.Text, m.Substituted
+}
 
-	return <-ch
+func (d *Display) readStatus(ctx context.Context) error {
+	return d.send(ctx, &proto.ToSplitflap{
+		Payload: &proto.ToSplitflap_RequestState{},
+	})
 }
 
 // Settings returns the current display settings.
 func (d *Display) Settings() *proto.Settings {
-	return d.lastStatus.Settings
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return gproto.Clone(d.currentSettings()).(*proto.Settings)
+}
+
+// currentSettings returns the settings most recently sent, or if none have
+// been, the ones the controller reported. It mustn't be modified. d.mu must
+// be held.
+func (d *Display) currentSettings() *proto.Settings {
+	if d.settings != nil {
+		return d.settings
+	}
+	if d.lastStatus.GetSettings() != nil {
+		return d.lastStatus.Settings
+	}
+	return &proto.Settings{}
 }
 
-// Text returns what the display is currently showing.
+// Text returns what the display is currently showing, with a line for each
+// row.
 func (d *Display) Text() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	return d.text
 }
 
@@ -449,58 +782,86 @@ func (d *Display) Text() string {
 // setting is on, the display will go through a full rotation when the character
 // for a cell is set to its current value.
 func (d *Display) SetForceRotation(on bool) error {
-	d.lastStatus.Settings.ForceFullRotation = on
-	return d.sendConfigCmd()
+	return d.SetForceRotationContext(context.Background(), on)
+}
+
+// SetForceRotationContext is like SetForceRotation, but gives up when ctx is
+// done.
+func (d *Display) SetForceRotationContext(ctx context.Context, on bool) error {
+	return d.sendConfigCmd(ctx, func(s *proto.Settings) {
+		s.ForceFullRotation = on
+	})
 }
 
 // SetMaxMoving sets the maximum number of cells that are allowed to be moving
 // at one time.
 func (d *Display) SetMaxMoving(max uint32) error {
-	d.lastStatus.Settings.MaxMoving = max
-	return d.sendConfigCmd()
+	return d.SetMaxMovingContext(context.Background(), max)
+}
+
+// SetMaxMovingContext is like SetMaxMoving, but gives up when ctx is done.
+func (d *Display) SetMaxMovingContext(ctx context.Context, max uint32) error {
+	return d.sendConfigCmd(ctx, func(s *proto.Settings) {
+		s.MaxMoving = max
+	})
 }
 
 // SetStartDelay sets the delay between starting one module and the next, in
 // milliseconds.
 func (d *Display) SetStartDelay(delay uint32) error {
-	d.lastStatus.Settings.StartDelayMillis = delay
-	return d.sendConfigCmd()
+	return d.SetStartDelayContext(context.Background(), delay)
+}
+
+// SetStartDelayContext is like SetStartDelay, but gives up when ctx is done.
+func (d *Display) SetStartDelayContext(ctx context.Context, delay uint32) error {
+	return d.sendConfigCmd(ctx, func(s *proto.Settings) {
+		s.StartDelayMillis = delay
+	})
 }
 
 // SetAnimStyle sets the animation style using the enum defined in the protobuf.
 func (d *Display) SetAnimStyle(animStyle string) error {
+	return d.SetAnimStyleContext(context.Background(), animStyle)
+}
+
+// SetAnimStyleContext is like SetAnimStyle, but gives up when ctx is done.
+func (d *Display) SetAnimStyleContext(ctx context.Context, animStyle string) error {
 	style, ok := proto.Settings_AnimationStyle_value[animStyle]
 	if !ok {
 		return errors.New("unknown animation style")
 	}
-	d.lastStatus.Settings.AnimationStyle = proto.Settings_AnimationStyle(style)
-	return d.sendConfigCmd()
+	return d.sendConfigCmd(ctx, func(s *proto.Settings) {
+		s.AnimationStyle = proto.Settings_AnimationStyle(style)
+	})
 }
 
-func (d *Display) sendConfigCmd() error {
-	ch := make(chan error)
-	req := sendReq{
-		msg: &proto.ToSplitflap{
-			Payload: &proto.ToSplitflap_SplitflapConfig{
-				SplitflapConfig: &proto.SplitflapConfig{
-					Settings: &proto.Settings{
-						ForceFullRotation: d.lastStatus.Settings.GetForceFullRotation(),
-						MaxMoving:         d.lastStatus.Settings.GetMaxMoving(),
-						StartDelayMillis:  d.lastStatus.Settings.GetStartDelayMillis(),
-						AnimationStyle:    d.lastStatus.Settings.GetAnimationStyle(),
-					},
-				},
+// sendConfigCmd applies change to the display's settings, and sends them.
+func (d *Display) sendConfigCmd(ctx context.Context, change func(*proto.Settings)) error {
+	d.mu.Lock()
+	settings := gproto.Clone(d.currentSettings()).(*proto.Settings)
+	change(settings)
+	d.settings = settings
+	d.mu.Unlock()
+	return d.sendSettings(ctx, settings)
+}
+
+func (d *Display) sendSettings(ctx context.Context, settings *proto.Settings) error {
+	return d.send(ctx, &proto.ToSplitflap{
+		Payload: &proto.ToSplitflap_SplitflapConfig{
+			SplitflapConfig: &proto.SplitflapConfig{
+				Settings: settings,
 			},
 		},
-		ch: ch,
-	}
-
-	d.toDisplay <- req
-	return <-ch
+	})
 }
 
 // Status returns the current state of the display: how big it is, what it's
-// showing, and error stats for each cell.
+// showing, and error stats for each cell. The modules are in layout order,
+// with the module map applied.
 func (d *Display) Status() *proto.SplitflapState {
-	return &d.lastStatus
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	st := gproto.Clone(d.lastStatus).(*proto.SplitflapState)
+	st.Modules = d.inLogicalOrder(st.Modules)
+	return st
 }
@@ -0,0 +1,941 @@
+package flapper_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trapgate/flapper"
+	"github.com/trapgate/flapper/flappertest"
+	"github.com/trapgate/flapper/proto"
+)
+
+// newTestDisplay returns a display on a fake controller with the given number
+// of modules. The display is closed when the test ends.
+func newTestDisplay(t *testing.T, modules int) (*flapper.Display, *flappertest.Controller) {
+	t.Helper()
+	c := flappertest.NewController(modules)
+	d, err := flapper.NewDisplayWithTransport(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	return d, c
+}
+
+// shown returns what the controller's modules are showing, in chain order,
+// assuming the default character set.
+func shown(c *flappertest.Controller) string {
+	flaps := []rune(flapper.DefaultFlaps)
+	var b strings.Builder
+	for _, m := range c.State().Modules {
+		b.WriteRune(flaps[m.FlapIndex])
+	}
+	return b.String()
+}
+
+// pad pads s with spaces to n runes.
+func pad(s string, n int) string {
+	return s + strings.Repeat(" ", n-len([]rune(s)))
+}
+
+// commands returns the module commands the display has sent.
+func commands(c *flappertest.Controller) [][]*proto.SplitflapCommand_ModuleCommand {
+	var cmds [][]*proto.SplitflapCommand_ModuleCommand
+	for _, msg := range c.Received() {
+		if cmd := msg.GetSplitflapCommand(); cmd != nil {
+			cmds = append(cmds, cmd.GetModules())
+		}
+	}
+	return cmds
+}
+
+func TestSetText(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	if n := d.Modules(); n != 24 {
+		t.Errorf("Modules() = %d, want 24", n)
+	}
+	err := d.SetText("hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := shown(c), pad("hello world", 24); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+	if n := len(commands(c)); n != 1 {
+		t.Errorf("sent %d commands, want 1", n)
+	}
+}
+
+// eventually polls cond until it's true, failing the test if it takes too
+// long.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	var mu sync.Mutex
+	var ctrls []*flappertest.Controller
+	current := func() *flappertest.Controller {
+		mu.Lock()
+		defer mu.Unlock()
+		return ctrls[len(ctrls)-1]
+	}
+	d, err := flapper.NewDisplayWithDialer(func() (flapper.Transport, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		c := flappertest.NewController(24)
+		ctrls = append(ctrls, c)
+		return c, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	states := make(chan flapper.ConnState, 10)
+	d.OnConnStateChange(func(s flapper.ConnState) { states <- s })
+
+	if err := d.SetMaxMoving(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetText("hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new controller has lost the settings and the text, and the display
+	// should put them back.
+	current().Close()
+	for _, want := range []flapper.ConnState{flapper.Reconnecting, flapper.Connected} {
+		select {
+		case s := <-states:
+			if s != want {
+				t.Fatalf("connection state %v, want %v", s, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+	c := current()
+	eventually(t, "the text to be restored", func() bool {
+		return shown(c) == pad("hello", 24)
+	})
+	if got := c.State().Settings.GetMaxMoving(); got != 3 {
+		t.Errorf("restored MaxMoving = %d, want 3", got)
+	}
+}
+
+func TestReconnectWithoutDialer(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	c.Close()
+	eventually(t, "the connection to fail", func() bool {
+		return d.ConnState() == flapper.Failed
+	})
+	if err := d.SetText("hello"); !errors.Is(err, flapper.ErrDisconnected) {
+		t.Errorf("SetText after failing = %v, want %v", err, flapper.ErrDisconnected)
+	}
+}
+
+// TestConcurrentAccess reads and changes the display from several goroutines
+// while state reports arrive, for the race detector.
+func TestConcurrentAccess(t *testing.T) {
+	d, _ := newTestDisplay(t, 24)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := d.SetMaxMoving(uint32(i + j)); err != nil {
+					t.Error(err)
+				}
+				if err := d.SetText(fmt.Sprint("text ", i, j)); err != nil {
+					t.Error(err)
+				}
+				d.Settings()
+				d.Text()
+				d.Status()
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// fastRetries retransmits quickly, so tests of retransmission don't take long.
+var fastRetries = flapper.RetryPolicy{
+	Timeout:    20 * time.Millisecond,
+	MaxRetries: 2,
+}
+
+func TestRetransmit(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	d.SetRetryPolicy(fastRetries)
+	c.DropAcks(1)
+	if err := d.SetText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(commands(c)); n != 2 {
+		t.Errorf("sent %d commands, want 2", n)
+	}
+	if got, want := shown(c), pad("hello", 24); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+}
+
+func TestNotAcknowledged(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	d.SetRetryPolicy(fastRetries)
+	c.DropAcks(100)
+	err := d.SetText("hello")
+	if !errors.Is(err, flapper.ErrNotAcknowledged) {
+		t.Errorf("SetText = %v, want %v", err, flapper.ErrNotAcknowledged)
+	}
+	if n := len(commands(c)); n != 3 {
+		t.Errorf("sent %d commands, want 3", n)
+	}
+}
+
+func TestSendCanceled(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	d.SetRetryPolicy(flapper.RetryPolicy{Timeout: 20 * time.Millisecond})
+	c.DropAcks(1000)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := d.SetTextContext(ctx, "hello")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SetTextContext = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// TestStrayAcks checks that acks nothing is waiting for don't hold up the
+// messages behind them.
+func TestStrayAcks(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	for i := 0; i < 50; i++ {
+		c.Send(&proto.FromSplitflap{
+			Payload: &proto.FromSplitflap_Ack{Ack: &proto.Ack{Nonce: 200}},
+		})
+	}
+	c.Send(&proto.FromSplitflap{
+		Payload: &proto.FromSplitflap_Log{Log: &proto.Log{Msg: "after the acks"}},
+	})
+	eventually(t, "the log message", func() bool {
+		logs := d.Logs()
+		return len(logs) == 1 && logs[0].Msg == "after the acks"
+	})
+	if err := d.SetText("hello"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	states := d.Subscribe(flapper.StateMessages, 0)
+	logs := d.Subscribe(flapper.LogMessages, 0)
+	defer states.Close()
+	defer logs.Close()
+
+	if err := d.SetText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	c.Send(&proto.FromSplitflap{
+		Payload: &proto.FromSplitflap_Log{Log: &proto.Log{Msg: "hi"}},
+	})
+	select {
+	case msg := <-logs.C:
+		if got := msg.GetLog().GetMsg(); got != "hi" {
+			t.Errorf("log subscription got %v, want the log message", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the log message")
+	}
+	// The state reports from SetText came before the log, so they're
+	// already waiting.
+	for len(states.C) > 0 {
+		if msg := <-states.C; msg.GetSplitflapState() == nil {
+			t.Errorf("state subscription got %v", msg)
+		}
+	}
+
+	logs.Close()
+	if _, ok := <-logs.C; ok {
+		t.Error("C is still open after Close")
+	}
+}
+
+// TestSubscriptionMessagesUnchanged checks that messages already delivered
+// aren't changed by the display afterwards.
+func TestSubscriptionMessagesUnchanged(t *testing.T) {
+	d, _ := newTestDisplay(t, 24)
+	sub := d.Subscribe(flapper.StateMessages, 0)
+	defer sub.Close()
+	if err := d.Init(); err != nil {
+		t.Fatal(err)
+	}
+	var msg *proto.FromSplitflap
+	select {
+	case msg = <-sub.C:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a state report")
+	}
+	if err := d.SetMaxMoving(7); err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.GetSplitflapState().GetSettings().GetMaxMoving(); got != 0 {
+		t.Errorf("delivered state has MaxMoving %d, want 0", got)
+	}
+	if got := d.Settings().GetMaxMoving(); got != 7 {
+		t.Errorf("Settings().MaxMoving = %d, want 7", got)
+	}
+}
+
+func TestSubscriptionDropsOldest(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	sub := d.Subscribe(flapper.LogMessages, 2)
+	defer sub.Close()
+	for _, msg := range []string{"one", "two", "three"} {
+		c.Send(&proto.FromSplitflap{
+			Payload: &proto.FromSplitflap_Log{Log: &proto.Log{Msg: msg}},
+		})
+	}
+	eventually(t, "a message to be dropped", func() bool {
+		return sub.Dropped() == 1
+	})
+	var got []string
+	for len(sub.C) > 0 {
+		got = append(got, (<-sub.C).GetLog().GetMsg())
+	}
+	if want := []string{"two", "three"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestResetModules(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	if err := d.SetText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	c.SetModuleState(1, proto.SplitflapState_ModuleState_SENSOR_ERROR)
+	if err := d.ResetModules(1); err != nil {
+		t.Fatal(err)
+	}
+	st := c.State()
+	if got := st.Modules[1].State; got != proto.SplitflapState_ModuleState_NORMAL {
+		t.Errorf("module 1 is %v after reset, want NORMAL", got)
+	}
+	if got, want := shown(c), pad("h llo", 24); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+	cmds := commands(c)
+	for i, mc := range cmds[len(cmds)-1] {
+		want := proto.SplitflapCommand_ModuleCommand_NO_OP
+		if i == 1 {
+			want = proto.SplitflapCommand_ModuleCommand_RESET_AND_HOME
+		}
+		if mc.Action != want {
+			t.Errorf("module %d sent %v, want %v", i, mc.Action, want)
+		}
+	}
+
+	if err := d.ResetModules(24); err == nil {
+		t.Error("ResetModules(24) succeeded on a 24 module display")
+	}
+}
+
+func TestSetTextAndWait(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.SetTextAndWait(ctx, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := shown(c), pad("hello", 24); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+}
+
+func TestWaitSettledFailed(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	c.SetModuleState(5, proto.SplitflapState_ModuleState_PANIC)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := d.WaitSettled(ctx)
+	var se *flapper.SettleError
+	if !errors.As(err, &se) {
+		t.Fatalf("WaitSettled = %v, want a *SettleError", err)
+	}
+	if !reflect.DeepEqual(se.Modules, []int{5}) || se.Err != nil {
+		t.Errorf("WaitSettled = %v, want module 5 to have failed", err)
+	}
+}
+
+func TestWaitSettledHeld(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	// The controller holds modules back when max_moving is set.
+	c.Hold(0, 1, 2)
+	if err := d.SetText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- d.WaitSettled(ctx)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("WaitSettled = %v before the held modules arrived", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	c.Release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got, want := shown(c), pad("hello", 24); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+}
+
+func TestHeldModulesKeepTargets(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	states := d.Subscribe(flapper.StateMessages, 0)
+	defer states.Close()
+	c.Hold(0)
+	if err := d.SetText("h"); err != nil {
+		t.Fatal(err)
+	}
+	// The report following the command still has module 0 on the blank
+	// flap, but it's only waiting its turn.
+	select {
+	case <-states.C:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the state report")
+	}
+	if err := d.SetText(""); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := actions(c), "G"+strings.Repeat("N", 23); got != want {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+	c.Release()
+	if got, want := shown(c), pad("", 24); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+}
+
+func TestGeometry(t *testing.T) {
+	d, c := newTestDisplay(t, 48)
+	if got, want := d.Geometry(), (flapper.Geometry{Rows: 4, Columns: 12}); got != want {
+		t.Errorf("Geometry() = %v, want %v", got, want)
+	}
+	if err := d.SetConfig(&flapper.Config{Rows: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d.Geometry(), (flapper.Geometry{Rows: 3, Columns: 16}); got != want {
+		t.Errorf("Geometry() = %v, want %v", got, want)
+	}
+	if err := d.SetText("the quick brown fox jumps"); err != nil {
+		t.Fatal(err)
+	}
+	want := pad("the quick brown", 16) + pad("fox jumps", 32)
+	if got := shown(c); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+	eventually(t, "the state report", func() bool {
+		return d.Text() == pad("the quick brown", 16)+"\n"+pad("fox jumps", 16)+"\n"+pad("", 16)
+	})
+}
+
+func TestGeometryInvalid(t *testing.T) {
+	d, _ := newTestDisplay(t, 48)
+	for _, cfg := range []flapper.Config{
+		{Rows: 4, Columns: 13},
+		{Rows: 49},
+		{Columns: 49},
+		{Rows: 2, Modules: 24},
+	} {
+		if err := d.SetConfig(&cfg); err == nil {
+			t.Errorf("SetConfig accepted geometry %dx%d", cfg.Rows, cfg.Columns)
+		}
+	}
+	if got, want := d.Geometry(), (flapper.Geometry{Rows: 4, Columns: 12}); got != want {
+		t.Errorf("after rejected configs, Geometry() = %v, want %v", got, want)
+	}
+}
+
+func TestModuleMap(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	// The first row is wired right to left.
+	cfg := &flapper.Config{ModuleMap: []int{11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1, 0}}
+	if err := d.SetConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetText("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := shown(c), pad("         cba", 24); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+	eventually(t, "the state report", func() bool {
+		return strings.HasPrefix(d.Text(), "abc")
+	})
+	st := d.Status()
+	for i, want := range []uint32{1, 2, 3, 0} {
+		if got := st.Modules[i].FlapIndex; got != want {
+			t.Errorf("Status() module %d at flap %d, want %d", i, got, want)
+		}
+	}
+
+	// Identify ignores the map.
+	if err := d.Identify(false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := shown(c), "012345678901234567890123"; got != want {
+		t.Errorf("after Identify, controller shows %q, want %q", got, want)
+	}
+	if err := d.Identify(true); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := shown(c), "000000000011111111112222"; got != want {
+		t.Errorf("after Identify(true), controller shows %q, want %q", got, want)
+	}
+}
+
+func TestModuleMapInvalid(t *testing.T) {
+	d, _ := newTestDisplay(t, 24)
+	for _, m := range [][]int{{0, 0}, {0, 2}, make([]int, 25)} {
+		if err := d.SetConfig(&flapper.Config{ModuleMap: m}); err == nil {
+			t.Errorf("SetConfig accepted module map %v", m)
+		}
+	}
+}
+
+func TestModulesRemoved(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	m := make([]int, 24)
+	for i := range m {
+		m[i] = 23 - i
+	}
+	if err := d.SetConfig(&flapper.Config{ModuleMap: m}); err != nil {
+		t.Fatal(err)
+	}
+	// The controller now reports half as many modules, so the map no
+	// longer fits.
+	st := c.State()
+	st.Modules = st.Modules[:12]
+	c.Send(&proto.FromSplitflap{
+		Payload: &proto.FromSplitflap_SplitflapState{SplitflapState: st},
+	})
+	eventually(t, "the state report", func() bool {
+		return d.Modules() == 12
+	})
+	if got, want := d.Geometry(), (flapper.Geometry{Rows: 1, Columns: 12}); got != want {
+		t.Errorf("Geometry() = %v, want %v", got, want)
+	}
+	if err := d.SetText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	cmds := commands(c)
+	if n := len(cmds[len(cmds)-1]); n != 12 {
+		t.Errorf("sent a command for %d modules, want 12", n)
+	}
+	if got, want := shown(c)[:12], pad("hello", 12); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+}
+
+func TestCalibration(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	flaps := []rune(flapper.DefaultFlaps)
+	reversed := make([]rune, len(flaps))
+	for i, r := range flaps {
+		reversed[len(flaps)-1-i] = r
+	}
+	err := d.SetConfig(&flapper.Config{Calibration: map[int]flapper.Calibration{
+		0: {Offset: 1},
+		1: {Flaps: string(reversed)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetText("ab"); err != nil {
+		t.Fatal(err)
+	}
+	st := c.State()
+	// Module 0 homes one flap late, so 'a' is one flap earlier.
+	if got := st.Modules[0].FlapIndex; got != 0 {
+		t.Errorf("module 0 sent to flap %d, want 0", got)
+	}
+	if got, want := st.Modules[1].FlapIndex, uint32(len(flaps)-3); got != want {
+		t.Errorf("module 1 sent to flap %d, want %d", got, want)
+	}
+	eventually(t, "the state report", func() bool {
+		return strings.HasPrefix(d.Text(), "ab ")
+	})
+
+	// MoveToFlap doesn't apply the calibration.
+	if err := d.MoveToFlap(0, 5); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.State().Modules[0].FlapIndex; got != 5 {
+		t.Errorf("MoveToFlap(0, 5) left module 0 at flap %d", got)
+	}
+	if err := d.MoveToFlap(0, len(flaps)); err == nil {
+		t.Errorf("MoveToFlap(0, %d) succeeded", len(flaps))
+	}
+
+	if err := d.SetCalibration(0, flapper.Calibration{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.Config().Calibration[0]; ok {
+		t.Error("SetCalibration with no calibration didn't clear it")
+	}
+	if err := d.SetCalibration(1, flapper.Calibration{Flaps: "abc"}); err == nil {
+		t.Error("SetCalibration accepted a character set of the wrong size")
+	}
+}
+
+// actions returns the action sent to each module by the last command, as a
+// string with a letter for each: G for GO_TO_FLAP, N for NO_OP, R for
+// RESET_AND_HOME.
+func actions(c *flappertest.Controller) string {
+	cmds := commands(c)
+	var b strings.Builder
+	for _, mc := range cmds[len(cmds)-1] {
+		b.WriteByte("NGR"[mc.Action])
+	}
+	return b.String()
+}
+
+func TestOnlyChangedModulesMove(t *testing.T) {
+	tests := []struct {
+		name  string
+		from  string
+		to    string
+		opts  *flapper.TextOptions
+		moved string
+	}{
+		{"unchanged", "hello", "hello", nil, ""},
+		{"changed", "hello", "help", nil, "   GG"},
+		{"forced", "hello", "hello", &flapper.TextOptions{ForceRotation: []int{1, 13}}, " G           G"},
+		{"changed words", "hello world", "hello worms",
+			&flapper.TextOptions{RotateChangedWords: true}, "      GGGGG"},
+		{"full", "hi", "hi", &flapper.TextOptions{Full: true}, strings.Repeat("G", 24)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, c := newTestDisplay(t, 24)
+			if err := d.SetText(tt.from); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := d.ShowTextOpts(context.Background(), tt.to, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			want := strings.ReplaceAll(pad(tt.moved, 24), " ", "N")
+			if got := actions(c); got != want {
+				t.Errorf("sent %v, want %v", got, want)
+			}
+			if got, want := shown(c), pad(tt.to, 24); got != want {
+				t.Errorf("controller shows %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestForcedRotationSettings checks that ForceFullRotation is turned on just
+// for a command that rotates unchanged modules.
+func TestForcedRotationSettings(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	if err := d.SetText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	n := len(c.Received())
+	_, err := d.ShowTextOpts(context.Background(), "hello", &flapper.TextOptions{ForceRotation: []int{0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, msg := range c.Received()[n:] {
+		switch {
+		case msg.GetSplitflapConfig() != nil:
+			got = append(got, fmt.Sprint("rotation ", msg.GetSplitflapConfig().GetSettings().GetForceFullRotation()))
+		case msg.GetSplitflapCommand() != nil:
+			got = append(got, "command")
+		}
+	}
+	if want := []string{"rotation true", "command", "rotation false"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
+
+// TestRetryAfterFailedSend checks that text that didn't reach the display is
+// sent again, rather than assumed to be there.
+func TestRetryAfterFailedSend(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	d.SetRetryPolicy(fastRetries)
+	c.DropAcks(3)
+	if err := d.SetText("hello"); !errors.Is(err, flapper.ErrNotAcknowledged) {
+		t.Fatalf("SetText = %v, want %v", err, flapper.ErrNotAcknowledged)
+	}
+	if err := d.SetText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := shown(c), pad("hello", 24); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+}
+
+// TestReportedFlapsWin checks that a module reported stopped somewhere other
+// than where it was sent is moved again.
+func TestReportedFlapsWin(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	if err := d.SetText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	// Module 0 slipped back to the home flap.
+	st := c.State()
+	st.Modules[0].FlapIndex = 0
+	c.Send(&proto.FromSplitflap{
+		Payload: &proto.FromSplitflap_SplitflapState{SplitflapState: st},
+	})
+	eventually(t, "the state report", func() bool {
+		return strings.HasPrefix(d.Text(), " ello")
+	})
+	if err := d.SetText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := actions(c), "G"+strings.Repeat("N", 23); got != want {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+}
+
+func TestSetCells(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	if err := d.SetText("hello world"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetDeadCells(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetCells(map[int]rune{0: 'J', 1: 'x', 12: 'é'}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := shown(c), pad("jello world e", 24); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+	if got, want := actions(c), "G"+strings.Repeat("N", 11)+"G"+strings.Repeat("N", 11); got != want {
+		t.Errorf("sent %v, want %v", got, want)
+	}
+	if err := d.SetCells(map[int]rune{24: 'a'}); err == nil {
+		t.Error("SetCells accepted cell 24 on a 24 module display")
+	}
+}
+
+func TestSetRegion(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	if err := d.SetText("aaaaaaaaaaaa\nbbbbbbbbbbbb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetRegion(0, 8, 4, 2, "hi there"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := shown(c), "aaaaaaaahi  bbbbbbbbther"; got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+	for _, r := range [][4]int{{0, 9, 4, 1}, {1, 0, 12, 2}, {0, 0, 0, 1}, {-1, 0, 1, 1}} {
+		if err := d.SetRegion(r[0], r[1], r[2], r[3], "x"); err == nil {
+			t.Errorf("SetRegion%v succeeded on a 2x12 display", r)
+		}
+	}
+}
+
+func TestStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		unsup   []flapper.Unsupported
+		trunc   int
+		dropped int
+	}{
+		{"fits", "hello world", nil, 0, 0},
+		{"substituted", "well-known", nil, 0, 0},
+		{"unsupported", "a#b\nc#", []flapper.Unsupported{{Rune: '#', Cell: 1}, {Rune: '#', Cell: 13}}, 0, 0},
+		{"truncated", "abcdefghijklmnopqrstuvwxyz", nil, 2, 0},
+		{"dropped", "a\nb\nc", nil, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, c := newTestDisplay(t, 24)
+			layout, err := d.ShowTextOpts(context.Background(), tt.text, &flapper.TextOptions{Strict: true})
+			fits := tt.unsup == nil && tt.trunc == 0 && tt.dropped == 0
+			var fe *flapper.FitError
+			if fits {
+				if err != nil {
+					t.Fatalf("ShowTextOpts(%q) = %v", tt.text, err)
+				}
+			} else if !errors.As(err, &fe) || fe.Layout != layout {
+				t.Fatalf("ShowTextOpts(%q) = %v, want a *FitError", tt.text, err)
+			}
+			if !reflect.DeepEqual(layout.Unsupported, tt.unsup) {
+				t.Errorf("Unsupported = %v, want %v", layout.Unsupported, tt.unsup)
+			}
+			if layout.Truncated != tt.trunc || layout.Dropped != tt.dropped {
+				t.Errorf("Truncated, Dropped = %d, %d, want %d, %d",
+					layout.Truncated, layout.Dropped, tt.trunc, tt.dropped)
+			}
+			if n, want := len(commands(c)), map[bool]int{true: 1, false: 0}[fits]; n != want {
+				t.Errorf("sent %d commands, want %d", n, want)
+			}
+		})
+	}
+}
+
+func TestUnsupportedShownBlank(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	layout, err := d.ShowText(context.Background(), "a#b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []flapper.Unsupported{{Rune: '#', Cell: 1}}; !reflect.DeepEqual(layout.Unsupported, want) {
+		t.Errorf("Unsupported = %v, want %v", layout.Unsupported, want)
+	}
+	if got, want := shown(c), pad("a b", 24); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+}
+
+func TestShowPagesStrict(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	opts := &flapper.TextOptions{Strict: true}
+	// The second page has a character the display can't show.
+	layouts, err := d.ShowPages(context.Background(), "page one\fpage #2", 0, opts)
+	var fe *flapper.FitError
+	if !errors.As(err, &fe) {
+		t.Fatalf("ShowPages = %v, want a *FitError", err)
+	}
+	if want := []flapper.Unsupported{{Rune: '#', Cell: 5}}; !reflect.DeepEqual(fe.Layout.Unsupported, want) {
+		t.Errorf("Unsupported = %v, want %v", fe.Layout.Unsupported, want)
+	}
+	if layouts != nil {
+		t.Errorf("ShowPages returned %d layouts, want none", len(layouts))
+	}
+	if n := len(commands(c)); n != 0 {
+		t.Errorf("sent %d commands, want none", n)
+	}
+
+	layouts, err = d.ShowPages(context.Background(), "page one\fpage two", 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layouts) != 2 {
+		t.Errorf("ShowPages returned %d layouts, want 2", len(layouts))
+	}
+	if got, want := shown(c), pad("page two", 24); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+}
+
+// supervisor returns a supervisor report.
+func supervisor(uptime uint32, fault proto.SupervisorState_FaultInfo_FaultType) *proto.FromSplitflap {
+	st := &proto.SupervisorState{UptimeMillis: uptime, State: proto.SupervisorState_NORMAL}
+	if fault != proto.SupervisorState_FaultInfo_NONE {
+		st.State = proto.SupervisorState_FAULT
+		st.FaultInfo = &proto.SupervisorState_FaultInfo{Type: fault, TsMillis: uptime}
+	}
+	return &proto.FromSplitflap{
+		Payload: &proto.FromSplitflap_SupervisorState{SupervisorState: st},
+	}
+}
+
+func TestOnFaultChange(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	faults := make(chan string, 10)
+	d.OnFaultChange(func(st *proto.SupervisorState) {
+		faults <- flapper.FaultString(st)
+	})
+	for i, fault := range []proto.SupervisorState_FaultInfo_FaultType{
+		proto.SupervisorState_FaultInfo_NONE,
+		proto.SupervisorState_FaultInfo_NONE,
+		proto.SupervisorState_FaultInfo_OVER_CURRENT,
+		proto.SupervisorState_FaultInfo_OVER_CURRENT,
+		proto.SupervisorState_FaultInfo_NONE,
+	} {
+		// The same fault at the same time is only reported once.
+		uptime := uint32(1000 * (i + 1))
+		if i == 3 {
+			uptime = 3000
+		}
+		c.Send(supervisor(uptime, fault))
+	}
+	c.Send(&proto.FromSplitflap{
+		Payload: &proto.FromSplitflap_Log{Log: &proto.Log{Msg: "done"}},
+	})
+	eventually(t, "the reports", func() bool { return len(d.Logs()) == 1 })
+
+	var got []string
+	for len(faults) > 0 {
+		got = append(got, <-faults)
+	}
+	want := []string{"fault OVER_CURRENT at 3000ms", "ok"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fault changes %q, want %q", got, want)
+	}
+	if st := d.Supervisor(); st.GetUptimeMillis() != 5000 {
+		t.Errorf("Supervisor() = %v, want the last report", st)
+	}
+}
+
+// TestControllerRestart checks that the display puts back its settings and
+// text when the supervisor's uptime shows the controller has restarted.
+func TestControllerRestart(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	if err := d.SetMaxMoving(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetText("hello"); err != nil {
+		t.Fatal(err)
+	}
+	c.Send(supervisor(60000, proto.SupervisorState_FaultInfo_NONE))
+	c.Send(supervisor(61000, proto.SupervisorState_FaultInfo_NONE))
+	n := len(c.Received())
+
+	c.Send(supervisor(100, proto.SupervisorState_FaultInfo_NONE))
+	var restored []*proto.ToSplitflap
+	eventually(t, "the text to be resent", func() bool {
+		restored = c.Received()[n:]
+		for _, msg := range restored {
+			if msg.GetSplitflapCommand() != nil {
+				return true
+			}
+		}
+		return false
+	})
+	var settings *proto.Settings
+	for _, msg := range restored {
+		if s := msg.GetSplitflapConfig().GetSettings(); s != nil {
+			settings = s
+		}
+		if cmd := msg.GetSplitflapCommand(); cmd != nil {
+			// Every cell is sent, as the controller may have lost them all.
+			for i, mc := range cmd.GetModules() {
+				if mc.Action != proto.SplitflapCommand_ModuleCommand_GO_TO_FLAP {
+					t.Errorf("module %d sent %v, want GO_TO_FLAP", i, mc.Action)
+				}
+			}
+		}
+	}
+	if settings.GetMaxMoving() != 3 {
+		t.Errorf("restored settings %v, want MaxMoving 3", settings)
+	}
+}
@@ -0,0 +1,265 @@
+// Package flappertest provides an in-memory splitflap controller, for testing
+// code that uses flapper.Display on machines with no hardware attached.
+package flappertest
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/trapgate/flapper"
+	"github.com/trapgate/flapper/proto"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// Controller is a fake splitflap controller. It implements flapper.Transport,
+// so a Display can be created on top of it with
+// flapper.NewDisplayWithTransport. Every frame the display writes is decoded
+// and recorded, acked, and answered with the controller's current state.
+// Commands take effect immediately: modules jump straight to their target flap.
+type Controller struct {
+	mu       sync.Mutex
+	partial  []byte // bytes of a frame that hasn't been terminated yet
+	received []*proto.ToSplitflap
+	state    *proto.SplitflapState
+	dropAcks int // the number of upcoming acks to drop
+
+	// held maps the modules being held back to the command queued for each.
+	held map[int]*proto.SplitflapCommand_ModuleCommand
+
+	replies chan []byte
+	pr      *io.PipeReader
+	pw      *io.PipeWriter
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewController returns a fake controller with the given number of modules,
+// all showing flap 0.
+func NewController(modules int) *Controller {
+	pr, pw := io.Pipe()
+	c := &Controller{
+		state:   &proto.SplitflapState{Settings: &proto.Settings{}},
+		replies: make(chan []byte, 64),
+		pr:      pr,
+		pw:      pw,
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < modules; i++ {
+		c.state.Modules = append(c.state.Modules, &proto.SplitflapState_ModuleState{})
+	}
+
+	// Replies are written from their own goroutine. The display only reads
+	// from the transport in between handling messages, so writing them from
+	// inside Write could deadlock.
+	go c.pump()
+	return c
+}
+
+// Read returns the frames the controller has sent back to the display.
+func (c *Controller) Read(p []byte) (int, error) {
+	return c.pr.Read(p)
+}
+
+// Write accepts framed ToSplitflap messages from the display. Frames may be
+// split across calls to Write.
+func (c *Controller) Write(p []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	c.mu.Lock()
+	c.partial = append(c.partial, p...)
+	var frames [][]byte
+	for {
+		i := bytes.IndexByte(c.partial, 0)
+		if i < 0 {
+			break
+		}
+		frames = append(frames, append([]byte(nil), c.partial[:i+1]...))
+		c.partial = c.partial[i+1:]
+	}
+	c.mu.Unlock()
+
+	for _, f := range frames {
+		c.handleFrame(f)
+	}
+	return len(p), nil
+}
+
+// Close shuts the controller down. Reads will return io.EOF afterwards.
+func (c *Controller) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		c.pw.Close()
+	})
+	return nil
+}
+
+// Received returns every message the display has sent, in order, including
+// retransmissions.
+func (c *Controller) Received() []*proto.ToSplitflap {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msgs := make([]*proto.ToSplitflap, len(c.received))
+	copy(msgs, c.received)
+	return msgs
+}
+
+// Last returns the most recent message sent by the display, or nil if it
+// hasn't sent anything yet.
+func (c *Controller) Last() *proto.ToSplitflap {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.received) == 0 {
+		return nil
+	}
+	return c.received[len(c.received)-1]
+}
+
+// State returns a copy of the controller's current state.
+func (c *Controller) State() *proto.SplitflapState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return gproto.Clone(c.state).(*proto.SplitflapState)
+}
+
+// SetModuleState changes the reported state of a single module, for simulating
+// faults. The new state is sent to the display.
+func (c *Controller) SetModuleState(module int, state proto.SplitflapState_ModuleState_State) {
+	c.mu.Lock()
+	c.state.Modules[module].State = state
+	c.mu.Unlock()
+	c.sendState()
+}
+
+// Hold holds the given modules back, as the firmware does when max_moving or
+// start_delay_millis are set: commands sent to them are acked, but they stay
+// where they are until Release is called.
+func (c *Controller) Hold(modules ...int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.held == nil {
+		c.held = make(map[int]*proto.SplitflapCommand_ModuleCommand)
+	}
+	for _, m := range modules {
+		c.held[m] = nil
+	}
+}
+
+// Release lets the held modules go, carrying out the last command queued for
+// each of them. The new state is sent to the display.
+func (c *Controller) Release() {
+	c.mu.Lock()
+	for i, mc := range c.held {
+		if mc != nil && i < len(c.state.Modules) {
+			apply(c.state.Modules[i], mc)
+		}
+	}
+	c.held = nil
+	c.mu.Unlock()
+	c.sendState()
+}
+
+// DropAcks makes the controller ignore the next n messages: they are recorded,
+// but not acked or acted on. This can be used to exercise the display's
+// retransmission.
+func (c *Controller) DropAcks(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropAcks = n
+}
+
+// Send sends an arbitrary message to the display, such as a Log or a
+// SupervisorState.
+func (c *Controller) Send(msg *proto.FromSplitflap) error {
+	b, err := flapper.MarshalFrame(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case c.replies <- b:
+	case <-c.done:
+		return io.ErrClosedPipe
+	}
+	return nil
+}
+
+func (c *Controller) pump() {
+	for {
+		select {
+		case b := <-c.replies:
+			if _, err := c.pw.Write(b); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Controller) handleFrame(b []byte) {
+	msg := &proto.ToSplitflap{}
+	if err := flapper.UnmarshalFrame(b, msg); err != nil {
+		// The real firmware silently drops frames it can't decode.
+		return
+	}
+
+	c.mu.Lock()
+	c.received = append(c.received, msg)
+	if c.dropAcks > 0 {
+		c.dropAcks--
+		c.mu.Unlock()
+		return
+	}
+	switch msg.Payload.(type) {
+	case *proto.ToSplitflap_SplitflapCommand:
+		for i, mc := range msg.GetSplitflapCommand().GetModules() {
+			if i >= len(c.state.Modules) || mc == nil {
+				break
+			}
+			if _, ok := c.held[i]; ok {
+				if mc.Action != proto.SplitflapCommand_ModuleCommand_NO_OP {
+					c.held[i] = mc
+				}
+				continue
+			}
+			apply(c.state.Modules[i], mc)
+		}
+	case *proto.ToSplitflap_SplitflapConfig:
+		if s := msg.GetSplitflapConfig().GetSettings(); s != nil {
+			c.state.Settings = gproto.Clone(s).(*proto.Settings)
+		}
+	}
+	c.mu.Unlock()
+
+	c.Send(&proto.FromSplitflap{
+		Payload: &proto.FromSplitflap_Ack{
+			Ack: &proto.Ack{Nonce: msg.Nonce},
+		},
+	})
+	c.sendState()
+}
+
+// apply carries out a command on a module.
+func apply(m *proto.SplitflapState_ModuleState, mc *proto.SplitflapCommand_ModuleCommand) {
+	switch mc.Action {
+	case proto.SplitflapCommand_ModuleCommand_GO_TO_FLAP:
+		m.FlapIndex = mc.Param
+	case proto.SplitflapCommand_ModuleCommand_RESET_AND_HOME:
+		m.FlapIndex = 0
+		m.State = proto.SplitflapState_ModuleState_NORMAL
+		m.CountMissedHome = 0
+		m.CountUnexpectedHome = 0
+	}
+}
+
+func (c *Controller) sendState() {
+	c.Send(&proto.FromSplitflap{
+		Payload: &proto.FromSplitflap_SplitflapState{
+			SplitflapState: c.State(),
+		},
+	})
+}
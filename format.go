@@ -0,0 +1,303 @@
+package flapper
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/trapgate/flapper/hyphen"
+)
+
+// Align is the horizontal alignment of a line of text.
+type Align int
+
+const (
+	AlignLeft Align = iota
+	AlignCenter
+	AlignRight
+)
+
+var alignNames = map[string]Align{
+	"left":   AlignLeft,
+	"center": AlignCenter,
+	"right":  AlignRight,
+}
+
+// ParseAlign converts "left", "center" or "right" to an Align.
+func ParseAlign(s string) (Align, error) {
+	a, ok := alignNames[strings.ToLower(s)]
+	if !ok {
+		return AlignLeft, fmt.Errorf("unknown alignment %q", s)
+	}
+	return a, nil
+}
+
+func (a Align) String() string {
+	for name, v := range alignNames {
+		if v == a {
+			return name
+		}
+	}
+	return fmt.Sprintf("Align(%d)", int(a))
+}
+
+// Format controls how text is laid out on the display. Each newline in the
+// text starts a new row, and spaces at the start of a line are kept.
+type Format struct {
+	// Align gives the alignment of each line of text, split at newlines.
+	// Rows that a line wraps onto are aligned the same way. Lines past the
+	// end of Align use its last entry, and if it's empty, every line is
+	// aligned left.
+	Align []Align
+	// VerticalCenter centers the text vertically when it doesn't fill the
+	// display.
+	VerticalCenter bool
+	// Hyphenate breaks words too long for a row between syllables, using
+	// English hyphenation patterns, and ends the row with a hyphen. It only
+	// works if the display has a hyphen flap; otherwise long words are
+	// broken wherever they reach the end of the row.
+	Hyphenate bool
+}
+
+// hyphenMarks are the flaps that can end a row when a word is hyphenated, in
+// order of preference.
+var hyphenMarks = []rune{'-', '‐', '‑', '–', '—'}
+
+// align returns the alignment of line i.
+func (f *Format) align(i int) Align {
+	if f == nil || len(f.Align) == 0 {
+		return AlignLeft
+	}
+	if i >= len(f.Align) {
+		i = len(f.Align) - 1
+	}
+	return f.Align[i]
+}
+
+// layoutBox lays text out in a box of width columns and height rows, with
+// each row padded to the full width. It also returns the number of lines of
+// text, split at newlines, that were dropped completely because there was no
+// room for them. If hyph isn't 0, long words are hyphenated with it, and the
+// number of hyphens added is returned too.
+func layoutBox(text string, width, height int, f *Format, hyph rune) ([]string, int, int) {
+	text = replaceControls(text)
+	var rows []string
+	dropped, hyphens := 0, 0
+	for i, line := range strings.Split(text, "\n") {
+		if len(rows) >= height {
+			if strings.TrimSpace(line) != "" {
+				dropped++
+			}
+			continue
+		}
+		wrapped, hyphenated := wrapLine(line, width, hyph)
+		for j, row := range wrapped {
+			if len(rows) >= height {
+				break
+			}
+			rows = append(rows, alignRow(row, width, f.align(i)))
+			if hyphenated[j] {
+				hyphens++
+			}
+		}
+	}
+	return fillBox(rows, width, height, f), dropped, hyphens
+}
+
+// layoutPages lays text out like layoutBox, but in as many boxes as it takes
+// to show all of it. A form feed in the text starts a new page.
+func layoutPages(text string, width, height int, f *Format, hyph rune) [][]string {
+	var pages [][]string
+	n := 0 // The number of lines, split at newlines, before this page.
+	for _, part := range strings.Split(text, "\f") {
+		part = replaceControls(part)
+		var rows []string
+		for i, line := range strings.Split(part, "\n") {
+			wrapped, _ := wrapLine(line, width, hyph)
+			for _, row := range wrapped {
+				rows = append(rows, alignRow(row, width, f.align(n+i)))
+			}
+		}
+		n += strings.Count(part, "\n") + 1
+		// Blank rows at the end don't need a page of their own.
+		for len(rows) > 0 && strings.TrimSpace(rows[len(rows)-1]) == "" {
+			rows = rows[:len(rows)-1]
+		}
+		for len(rows) > height {
+			pages = append(pages, fillBox(rows[:height], width, height, f))
+			rows = rows[height:]
+		}
+		if len(rows) > 0 {
+			pages = append(pages, fillBox(rows, width, height, f))
+		}
+	}
+	if len(pages) == 0 {
+		pages = append(pages, fillBox(nil, width, height, f))
+	}
+	return pages
+}
+
+// replaceControls replaces the control characters in text, which no display
+// has flaps for. Tabs become spaces, vertical tabs and form feeds become
+// newlines, and the rest, apart from newlines, are dropped.
+func replaceControls(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n':
+			return r
+		case r == '\t':
+			return ' '
+		case r == '\v', r == '\f':
+			return '\n'
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// fillBox pads rows out to height rows of width spaces, centering them
+// vertically if f says to.
+func fillBox(rows []string, width, height int, f *Format) []string {
+	top := 0
+	if f != nil && f.VerticalCenter {
+		top = (height - len(rows)) / 2
+	}
+	blank := strings.Repeat(" ", width)
+	box := make([]string, 0, height)
+	for len(box) < top {
+		box = append(box, blank)
+	}
+	box = append(box, rows...)
+	for len(box) < height {
+		box = append(box, blank)
+	}
+	return box
+}
+
+// wrapLine breaks a line of text into rows no more than width runes long,
+// breaking between words where it can. Spaces at the start of the line are
+// kept, as are runs of spaces between words on the same row. A word longer
+// than a row is broken across rows, and the words after it carry on from
+// where it ends. If hyph isn't 0, such words are broken between syllables
+// where possible, with hyph at the end of the row, and can start on the row
+// before; hyphenated reports which rows end with an added hyph.
+func wrapLine(line string, width int, hyph rune) (rows []string, hyphenated []bool) {
+	if width < 1 {
+		return []string{""}, []bool{false}
+	}
+	var row []rune
+	content := false // Whether row has anything other than the indent.
+	text := []rune(line)
+	for i := 0; i < len(text); {
+		gap := 0
+		for i < len(text) && text[i] == ' ' {
+			gap++
+			i++
+		}
+		start := i
+		for i < len(text) && text[i] != ' ' {
+			i++
+		}
+		word := text[start:i]
+		if len(word) == 0 {
+			// Spaces at the end of the line don't show.
+			break
+		}
+
+		// A word that's too long for any row can start on this one if it
+		// can be hyphenated to fit.
+		var points []int
+		if hyph != 0 && len(word) > width {
+			points = breakPoints(word)
+		}
+		startHere := len(points) > 0 && len(row)+gap+points[0]+1 <= width
+
+		switch {
+		case content && len(row)+gap+len(word) > width && !startHere:
+			// Start a new row, leaving the gap behind.
+			rows = append(rows, string(row))
+			hyphenated = append(hyphenated, false)
+			row = nil
+		case content:
+			row = append(row, []rune(strings.Repeat(" ", gap))...)
+		case len(rows) == 0:
+			// The indent of the first row, leaving room for something
+			// after it.
+			if gap > width-1 {
+				gap = width - 1
+			}
+			row = append(row, []rune(strings.Repeat(" ", gap))...)
+		}
+
+		done := 0 // How much of the word has been placed.
+		for len(row)+len(word)-done > width {
+			n := width - len(row)
+			// Use the last syllable break that leaves room for the hyphen.
+			p := 0
+			for _, pt := range points {
+				if pt > done && pt-done+1 <= n {
+					p = pt
+				}
+			}
+			if p > 0 {
+				row = append(append(row, word[done:p]...), hyph)
+				done = p
+			} else {
+				row = append(row, word[done:done+n]...)
+				done += n
+			}
+			rows = append(rows, string(row))
+			hyphenated = append(hyphenated, p > 0)
+			row = nil
+		}
+		word = word[done:]
+		row = append(row, word...)
+		content = len(row) > 0
+	}
+	if content || len(rows) == 0 {
+		rows = append(rows, string(row))
+		hyphenated = append(hyphenated, false)
+	}
+	return rows, hyphenated
+}
+
+// breakPoints returns the places a word can be hyphenated. Punctuation at
+// either end is left out, and words with anything else that isn't a letter
+// in them aren't hyphenated.
+func breakPoints(word []rune) []int {
+	start, end := 0, len(word)
+	for start < end && !unicode.IsLetter(word[start]) {
+		start++
+	}
+	for end > start && !unicode.IsLetter(word[end-1]) {
+		end--
+	}
+	for _, r := range word[start:end] {
+		if !unicode.IsLetter(r) {
+			return nil
+		}
+	}
+	points := hyphen.English().Points(string(word[start:end]))
+	for i := range points {
+		points[i] += start
+	}
+	return points
+}
+
+// alignRow pads row out to width, placing it as a says.
+func alignRow(row string, width int, a Align) string {
+	row = strings.TrimRight(row, " ")
+	pad := width - len([]rune(row))
+	if pad <= 0 {
+		return row
+	}
+	left := 0
+	switch a {
+	case AlignCenter:
+		left = pad / 2
+	case AlignRight:
+		left = pad
+	}
+	return strings.Repeat(" ", left) + row + strings.Repeat(" ", pad-left)
+}
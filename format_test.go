@@ -0,0 +1,225 @@
+package flapper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWrapLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		width int
+		hyph  rune
+		want  []string
+	}{
+		{"empty", "", 12, 0, []string{""}},
+		{"fits", "hello world", 12, 0, []string{"hello world"}},
+		{"exact", "hello worlds", 12, 0, []string{"hello worlds"}},
+		{"wraps", "hello there world", 12, 0, []string{"hello there", "world"}},
+		{"leading spaces", "   hello", 12, 0, []string{"   hello"}},
+		{"indent wraps", "  hello there", 12, 0, []string{"  hello", "there"}},
+		{"inner spaces kept", "a   b", 12, 0, []string{"a   b"}},
+		{"gap dropped at break", "hello    world", 8, 0, []string{"hello", "world"}},
+		{"trailing spaces", "hello   ", 12, 0, []string{"hello"}},
+		{"only spaces", "     ", 12, 0, []string{""}},
+		// A word longer than a row used to lose the rest of the text.
+		{"long first word", "supercalifragilistic is", 12, 0,
+			[]string{"supercalifra", "gilistic is"}},
+		{"long word after short", "a supercalifragilistic", 12, 0,
+			[]string{"a", "supercalifra", "gilistic"}},
+		{"long word exact multiple", "abcdefghijklmnopqrstuvwx yz", 12, 0,
+			[]string{"abcdefghijkl", "mnopqrstuvwx", "yz"}},
+		{"indent wider than row", "              hi", 12, 0, []string{"           h", "i"}},
+		{"multibyte", "ñañaña ñañaña", 6, 0, []string{"ñañaña", "ñañaña"}},
+		{"zero width", "hello", 0, 0, []string{""}},
+		// Hyphenation only applies to words longer than a row.
+		{"hyphenated", "international", 12, '-', []string{"interna-", "tional"}},
+		{"hyphenated after short", "an international", 12, '-',
+			[]string{"an interna-", "tional"}},
+		{"starts on the next row", "hello computerization", 8, '-',
+			[]string{"hello", "comput-", "eriza-", "tion"}},
+		{"short word not hyphenated", "hello internal", 12, '-',
+			[]string{"hello", "internal"}},
+		{"hyphenated punctuation", "(international)", 12, '-',
+			[]string{"(interna-", "tional)"}},
+		{"no break fits", "strengths", 4, '-', []string{"stre", "ngth", "s"}},
+		{"hyphen needs room", "computer", 3, '-', []string{"com", "put", "er"}},
+		{"hyphenated twice", "internationalization", 8, '-',
+			[]string{"interna-", "tional-", "ization"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := wrapLine(tt.line, tt.width, tt.hyph)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("wrapLine(%q, %d) = %q, want %q", tt.line, tt.width, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLayoutBox(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		width       int
+		height      int
+		format      *Format
+		hyph        rune
+		want        []string
+		wantDropped int
+		wantHyphens int
+	}{
+		{
+			name: "default", text: "hello", width: 12, height: 2,
+			want: []string{"hello       ", "            "},
+		},
+		{
+			name: "wraps onto second row", text: "hello there world", width: 12, height: 2,
+			want: []string{"hello there ", "world       "},
+		},
+		{
+			name: "row break honored", text: "hi\nthere", width: 12, height: 2,
+			want: []string{"hi          ", "there       "},
+		},
+		{
+			name: "blank first row", text: "\nthere", width: 12, height: 2,
+			want: []string{"            ", "there       "},
+		},
+		{
+			name: "second row indented", text: "top\n    indented", width: 12, height: 2,
+			want: []string{"top         ", "    indented"},
+		},
+		{
+			name: "long first word keeps the rest", text: "supercalifragilistic is", width: 12, height: 2,
+			want: []string{"supercalifra", "gilistic is "},
+		},
+		{
+			name: "lines dropped", text: "one\ntwo\nthree\n\nfour", width: 12, height: 2,
+			want:        []string{"one         ", "two         "},
+			wantDropped: 2,
+		},
+		{
+			name: "wrapped rows cut", text: "one two three", width: 4, height: 2,
+			want: []string{"one ", "two "},
+		},
+		{
+			name: "center", text: "hello", width: 12, height: 1,
+			format: &Format{Align: []Align{AlignCenter}},
+			want:   []string{"   hello    "},
+		},
+		{
+			name: "right", text: "hello", width: 12, height: 1,
+			format: &Format{Align: []Align{AlignRight}},
+			want:   []string{"       hello"},
+		},
+		{
+			name: "per line", text: "a\nb\nc", width: 5, height: 3,
+			format: &Format{Align: []Align{AlignLeft, AlignCenter, AlignRight}},
+			want:   []string{"a    ", "  b  ", "    c"},
+		},
+		{
+			name: "last alignment carries on", text: "a\nb\nc", width: 5, height: 3,
+			format: &Format{Align: []Align{AlignLeft, AlignRight}},
+			want:   []string{"a    ", "    b", "    c"},
+		},
+		{
+			name: "wrapped rows share alignment", text: "hello there", width: 7, height: 2,
+			format: &Format{Align: []Align{AlignRight}},
+			want:   []string{"  hello", "  there"},
+		},
+		{
+			name: "vertical center", text: "hi", width: 4, height: 3,
+			format: &Format{VerticalCenter: true},
+			want:   []string{"    ", "hi  ", "    "},
+		},
+		{
+			name: "vertical center extra row below", text: "a\nb", width: 4, height: 5,
+			format: &Format{Align: []Align{AlignCenter}, VerticalCenter: true},
+			want:   []string{"    ", " a  ", " b  ", "    ", "    "},
+		},
+		{
+			name: "vertical center full", text: "a\nb\nc", width: 2, height: 2,
+			format:      &Format{VerticalCenter: true},
+			want:        []string{"a ", "b "},
+			wantDropped: 1,
+		},
+		{
+			name: "control characters", text: "a\tb\fc\x07d", width: 4, height: 2,
+			want: []string{"a b ", "cd  "},
+		},
+		{
+			name: "hyphenated", text: "the international", width: 12, height: 3,
+			hyph:        '-',
+			want:        []string{"the interna-", "tional      ", "            "},
+			wantHyphens: 1,
+		},
+		{
+			name: "hyphenated row cut", text: "the internationalization", width: 12, height: 1,
+			hyph:        '-',
+			want:        []string{"the interna-"},
+			wantHyphens: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dropped, hyphens := layoutBox(tt.text, tt.width, tt.height, tt.format, tt.hyph)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("layoutBox(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if dropped != tt.wantDropped {
+				t.Errorf("layoutBox(%q) dropped %d lines, want %d", tt.text, dropped, tt.wantDropped)
+			}
+			if hyphens != tt.wantHyphens {
+				t.Errorf("layoutBox(%q) added %d hyphens, want %d", tt.text, hyphens, tt.wantHyphens)
+			}
+		})
+	}
+}
+
+func TestParseAlign(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Align
+		wantErr bool
+	}{
+		{"left", AlignLeft, false},
+		{"Center", AlignCenter, false},
+		{"RIGHT", AlignRight, false},
+		{"middle", AlignLeft, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAlign(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseAlign(%q) = %v, %v; want %v, error %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLayoutPages(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		format *Format
+		want   [][]string
+	}{
+		{"empty", "", nil, [][]string{{"    ", "    "}}},
+		{"one page", "ab", nil, [][]string{{"ab  ", "    "}}},
+		{"wraps onto pages", "one two three", nil,
+			[][]string{{"one ", "two "}, {"thre", "e   "}}},
+		{"trailing blank lines", "a\nb\n\n\n", nil, [][]string{{"a   ", "b   "}}},
+		{"form feed", "a\fb", nil, [][]string{{"a   ", "    "}, {"b   ", "    "}}},
+		{"alignment follows lines", "a\nb\nc", &Format{Align: []Align{AlignLeft, AlignLeft, AlignRight}},
+			[][]string{{"a   ", "b   "}, {"   c", "    "}}},
+		{"vertical center", "a\nb\nc", &Format{VerticalCenter: true},
+			[][]string{{"a   ", "b   "}, {"c   ", "    "}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := layoutPages(tt.text, 4, 2, tt.format, 0)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("layoutPages(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package flapper
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+
+	"github.com/dgryski/go-cobs"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// MarshalFrame encodes a protobuf message the way the splitflap controller
+// expects it on the wire: the serialized message, followed by a little-endian
+// crc32 of the message, cobs-encoded and terminated by a zero byte.
+func MarshalFrame(msg gproto.Message) ([]byte, error) {
+	b, err := gproto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Append the crc32 value to the end of the payload before sending it.
+	crc := crc32.ChecksumIEEE(b)
+	crcBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(crcBytes, crc)
+	b = append(b, crcBytes...)
+
+	// cobs is used to encode the buffer with no zero bytes.
+	cb := append(cobs.Encode(b), byte(0))
+	return cb, nil
+}
+
+// UnmarshalFrame is the inverse of MarshalFrame. It decodes a single
+// zero-terminated frame into msg, verifying the crc along the way.
+func UnmarshalFrame(b []byte, msg gproto.Message) error {
+	// Frames include a 4-byte crc and a terminating null, or else discard them.
+	if len(b) < 5 {
+		// Empty frame. Just keep trying.
+		return errors.New("empty frame")
+	}
+	// decode the buffer. Don't include the zero byte.
+	b, err := cobs.Decode(b[:len(b)-1])
+	if err != nil {
+		return errors.New("failed to decode cobs frame")
+	}
+	if len(b) < 4 {
+		return errors.New("short frame")
+	}
+
+	crcBytes := b[len(b)-4:]
+	b = b[:len(b)-4]
+	crc := binary.LittleEndian.Uint32(crcBytes)
+	if crc32.ChecksumIEEE(b) != crc {
+		return errors.New("bad crc")
+	}
+
+	err = gproto.Unmarshal(b, msg)
+	if err != nil {
+		return errors.New("failed to unmarshal protobuf message")
+	}
+	return nil
+}
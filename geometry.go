@@ -0,0 +1,78 @@
+package flapper
+
+import "fmt"
+
+// defaultColumns is the width of a display whose geometry isn't configured,
+// as long as the module count allows it.
+const defaultColumns = 12
+
+// Geometry describes how a display's modules are arranged into rows and
+// columns. Modules are numbered across each row, starting at the top left.
+type Geometry struct {
+	Rows    int
+	Columns int
+}
+
+// Cells returns the number of modules covered by the geometry.
+func (g Geometry) Cells() int {
+	return g.Rows * g.Columns
+}
+
+// Module returns the index of the module at a row and column.
+func (g Geometry) Module(row, col int) int {
+	return row*g.Columns + col
+}
+
+// Position returns the row and column of a module.
+func (g Geometry) Position(module int) (row, col int) {
+	return module / g.Columns, module % g.Columns
+}
+
+func (g Geometry) String() string {
+	return fmt.Sprintf("%dx%d", g.Rows, g.Columns)
+}
+
+// geometry works out the layout of a display with the given number of
+// modules. Rows or columns that aren't configured are filled in from the
+// module count.
+func (cfg *Config) geometry(modules int) (Geometry, error) {
+	if cfg.Rows < 0 || cfg.Columns < 0 {
+		return Geometry{}, fmt.Errorf("invalid geometry %dx%d", cfg.Rows, cfg.Columns)
+	}
+	g := Geometry{Rows: cfg.Rows, Columns: cfg.Columns}
+	switch {
+	case g.Rows == 0 && g.Columns == 0:
+		g.Columns = defaultColumns
+		if modules%defaultColumns != 0 {
+			g.Columns = modules
+		}
+		if g.Columns > 0 {
+			g.Rows = modules / g.Columns
+		}
+	case g.Rows == 0:
+		g.Rows = modules / g.Columns
+	case g.Columns == 0:
+		g.Columns = modules / g.Rows
+	}
+	if g.Cells() == 0 || g.Cells() > modules {
+		return Geometry{}, fmt.Errorf("geometry %v doesn't fit a display with %d modules",
+			g, modules)
+	}
+	return g, nil
+}
+
+// fitConfig works out the geometry and module map again once the controller
+// has reported the module count. If the configured ones don't fit, the
+// defaults are used instead. d.mu must be held.
+func (d *Display) fitConfig() {
+	g, err := d.cfg.geometry(d.cells)
+	if err != nil {
+		fmt.Println(err)
+		g, _ = (&Config{}).geometry(d.cells)
+	}
+	d.geom = g
+	d.toPhys, d.toLog, err = d.cfg.moduleMap(d.cells)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
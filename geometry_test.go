@@ -0,0 +1,50 @@
+package flapper
+
+import "testing"
+
+func TestGeometry(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		modules int
+		want    Geometry
+		wantErr bool
+	}{
+		{"default", Config{}, 24, Geometry{2, 12}, false},
+		{"default tall", Config{}, 48, Geometry{4, 12}, false},
+		{"default single row", Config{}, 10, Geometry{1, 10}, false},
+		{"rows", Config{Rows: 3}, 48, Geometry{3, 16}, false},
+		{"columns", Config{Columns: 16}, 48, Geometry{3, 16}, false},
+		{"both", Config{Rows: 3, Columns: 16}, 48, Geometry{3, 16}, false},
+		{"spare modules", Config{Rows: 2, Columns: 10}, 24, Geometry{2, 10}, false},
+		{"too many cells", Config{Rows: 4, Columns: 13}, 48, Geometry{}, true},
+		{"too many rows", Config{Rows: 49}, 48, Geometry{}, true},
+		{"too many columns", Config{Columns: 25}, 24, Geometry{}, true},
+		{"negative", Config{Rows: -1}, 24, Geometry{}, true},
+		{"no modules", Config{}, 0, Geometry{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cfg.geometry(tt.modules)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("geometry(%d) error = %v, want error %v", tt.modules, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("geometry(%d) = %v, want %v", tt.modules, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeometryPositions(t *testing.T) {
+	g := Geometry{Rows: 3, Columns: 16}
+	for m := 0; m < g.Cells(); m++ {
+		row, col := g.Position(m)
+		if row != m/16 || col != m%16 {
+			t.Errorf("Position(%d) = %d, %d, want %d, %d", m, row, col, m/16, m%16)
+		}
+		if got := g.Module(row, col); got != m {
+			t.Errorf("Module(%d, %d) = %d, want %d", row, col, got, m)
+		}
+	}
+}
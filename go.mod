@@ -7,9 +7,9 @@ require (
 	github.com/charmbracelet/lipgloss v0.7.1
 	github.com/dgryski/go-cobs v0.0.0-20211104005220-29d497e3aad1
 	github.com/golang/protobuf v1.5.2
-	github.com/muesli/reflow v0.3.0
 	github.com/trapgate/go-quake v0.0.0-00010101000000-000000000000
 	go.bug.st/serial v1.3.5
+	golang.org/x/sys v0.6.0
 	golang.org/x/text v0.11.0
 	google.golang.org/protobuf v1.28.1
 )
@@ -21,10 +21,10 @@ require (
 	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/mattn/go-runewidth v0.0.14 // indirect
+	github.com/muesli/reflow v0.3.0 // indirect
 	github.com/muesli/termenv v0.15.1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
-	golang.org/x/sys v0.6.0 // indirect
 )
 
 replace github.com/trapgate/splitflap => /home/geoff/splitflap
@@ -0,0 +1,174 @@
+// Package hyphen finds the places words can be hyphenated, using Liang's
+// pattern-based algorithm, as used by TeX. US English patterns are built in.
+package hyphen
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+var (
+	//go:embed hyph-en-us.pat
+	enPatterns string
+	//go:embed hyph-en-us.hyp
+	enExceptions string
+
+	english     *Hyphenator
+	englishOnce sync.Once
+)
+
+// Hyphenator finds hyphenation points using a set of patterns.
+type Hyphenator struct {
+	// LeftMin and RightMin are the fewest letters that can be left before
+	// and after a hyphen.
+	LeftMin  int
+	RightMin int
+
+	patterns   map[string][]int // Break priorities by pattern letters.
+	maxLen     int              // The length of the longest pattern.
+	exceptions map[string][]int // Break points of words not to use patterns on.
+}
+
+// English returns a Hyphenator for US English, using the patterns from TeX.
+func English() *Hyphenator {
+	englishOnce.Do(func() {
+		var err error
+		english, err = New(strings.NewReader(enPatterns), strings.NewReader(enExceptions))
+		if err != nil {
+			panic(err)
+		}
+	})
+	return english
+}
+
+// New returns a Hyphenator using TeX-style patterns, one or more to a line,
+// like "hy3ph". Exceptions are words with hyphens at the places they can be
+// broken, like "ta-ble", and are used instead of the patterns. Lines starting
+// with '%' are ignored in both. exceptions may be nil.
+func New(patterns, exceptions io.Reader) (*Hyphenator, error) {
+	h := &Hyphenator{
+		LeftMin:    2,
+		RightMin:   3,
+		patterns:   make(map[string][]int),
+		exceptions: make(map[string][]int),
+	}
+	err := readWords(patterns, func(p string) error {
+		var letters []rune
+		values := []int{0}
+		for _, r := range p {
+			if unicode.IsDigit(r) {
+				values[len(values)-1] = int(r - '0')
+				continue
+			}
+			letters = append(letters, unicode.ToLower(r))
+			values = append(values, 0)
+		}
+		if len(letters) == 0 {
+			return fmt.Errorf("pattern %q has no letters", p)
+		}
+		h.patterns[string(letters)] = values
+		if len(letters) > h.maxLen {
+			h.maxLen = len(letters)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if exceptions == nil {
+		return h, nil
+	}
+	err = readWords(exceptions, func(w string) error {
+		var letters []rune
+		var points []int
+		for _, r := range w {
+			if r == '-' {
+				points = append(points, len(letters))
+				continue
+			}
+			letters = append(letters, unicode.ToLower(r))
+		}
+		h.exceptions[string(letters)] = points
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// readWords calls f with each word in r, skipping comment lines.
+func readWords(r io.Reader, f func(string) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "%") {
+			continue
+		}
+		for _, w := range strings.Fields(line) {
+			if err := f(w); err != nil {
+				return err
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// Points returns the places word can be hyphenated, as the number of runes
+// before each one, in increasing order.
+func (h *Hyphenator) Points(word string) []int {
+	letters := []rune(strings.ToLower(word))
+	if len(letters) < h.LeftMin+h.RightMin {
+		return nil
+	}
+	if points, ok := h.exceptions[string(letters)]; ok {
+		return append([]int(nil), points...)
+	}
+
+	// Apply every pattern that matches part of the word, with a '.' at each
+	// end, keeping the highest value seen at each position. values[i] is for
+	// a break before the rune at i in the dotted word.
+	dotted := append(append([]rune{'.'}, letters...), '.')
+	values := make([]int, len(dotted)+1)
+	for i := range dotted {
+		for j := i + 1; j <= len(dotted) && j-i <= h.maxLen; j++ {
+			pattern, ok := h.patterns[string(dotted[i:j])]
+			if !ok {
+				continue
+			}
+			for k, v := range pattern {
+				if v > values[i+k] {
+					values[i+k] = v
+				}
+			}
+		}
+	}
+
+	// Odd values allow a break.
+	var points []int
+	for i := h.LeftMin; i <= len(letters)-h.RightMin; i++ {
+		if values[i+1]%2 == 1 {
+			points = append(points, i)
+		}
+	}
+	return points
+}
+
+// Hyphenate returns word with sep inserted at each place it can be broken.
+func (h *Hyphenator) Hyphenate(word, sep string) string {
+	runes := []rune(word)
+	b := strings.Builder{}
+	last := 0
+	for _, p := range h.Points(word) {
+		b.WriteString(string(runes[last:p]))
+		b.WriteString(sep)
+		last = p
+	}
+	b.WriteString(string(runes[last:]))
+	return b.String()
+}
@@ -0,0 +1,47 @@
+package hyphen
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEnglish(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"international", "in-ter-na-tional"},
+		{"computer", "com-puter"},
+		{"Computer", "Com-puter"},
+		{"typesetting", "type-set-ting"},
+		{"table", "ta-ble"},
+		{"cat", "cat"},
+		{"", ""},
+		// From the exceptions rather than the patterns.
+		{"accusative", "ac-cu-sa-tive"},
+		{"hyphenation", "hy-phen-a-tion"},
+	}
+	for _, tt := range tests {
+		if got := English().Hyphenate(tt.word, "-"); got != tt.want {
+			t.Errorf("Hyphenate(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	h, err := New(strings.NewReader("% comment\n1na\n"), strings.NewReader("ba-nan-a\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.LeftMin, h.RightMin = 1, 1
+	if got, want := h.Points("anana"), []int{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Points(anana) = %v, want %v", got, want)
+	}
+	if got, want := h.Points("banana"), []int{2, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Points(banana) = %v, want %v", got, want)
+	}
+	if _, err := New(strings.NewReader("12"), nil); err == nil {
+		t.Error("New accepted a pattern with no letters")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
-	"strings"
 	"time"
 
 	"github.com/trapgate/flapper"
@@ -84,7 +83,7 @@ func (q *QuakeMon) Run(ctx context.Context, display *flapper.Display) {
 			if err != nil {
 				fmt.Println("failed to fetch quake list", err)
 			}
-			q.print(display, quakes)
+			q.print(ctx, display, quakes)
 			showing = true
 		case <-q.resetCh:
 			if enable && !t.Stop() {
@@ -118,7 +117,7 @@ func (q *QuakeMon) Reset() {
 	q.resetCh <- struct{}{}
 }
 
-func (q *QuakeMon) print(display *flapper.Display, quakes quake.QuakeList) error {
+func (q *QuakeMon) print(ctx context.Context, display *flapper.Display, quakes quake.QuakeList) error {
 	sort.Sort(sort.Reverse(byMag(quakes.Features)))
 
 	// Display the largest quake
@@ -134,36 +133,16 @@ func (q *QuakeMon) print(display *flapper.Display, quakes quake.QuakeList) error
 	}
 	q.currentQuakeURL = quakes.Features[0].Properties.URL
 
-	desc = truncate(desc)
 	fmt.Println("quake monitor text:", desc)
-	return display.SetText(desc)
+	_, err := display.ShowTextOpts(ctx, desc, &flapper.TextOptions{Transform: shorten})
+	return err
 }
 
-var abbrevs = map[string]string{
-	"north":     "n",
-	"south":     "s",
-	"east":      "e",
-	"west":      "w",
-	"northeast": "ne",
-	"northwest": "nw",
-	"southeast": "se",
-	"southwest": "sw",
-	"islands":   "is.",
-}
-
-// truncate removes some specific data and uses abbreviations to shorten the
-// place name as much as possible.
-func truncate(desc string) string {
-	// Get rid of strings like, '150 km NE of ' from the place.
-	re := regexp.MustCompile(`\d+ km [NSEW]+ of `)
-	desc = re.ReplaceAllString(desc, "")
-	words := strings.Split(desc, " ")
-	for i := range words {
-		rep, ok := abbrevs[strings.ToLower(words[i])]
-		if ok {
-			words[i] = rep
-		}
-	}
-	desc = strings.Join(words, " ")
-	return desc
-}
+// shorten abbreviates the place name as much as it needs to fit the display.
+// As a last resort, it gets rid of strings like, '150 km NE of ' from the
+// place.
+var shorten = func() *flapper.Abbreviator {
+	a := flapper.NewAbbreviator(nil)
+	a.AddPattern(regexp.MustCompile(`\d+ km [NSEW]+ of `), "")
+	return a
+}()
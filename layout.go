@@ -0,0 +1,274 @@
+package flapper
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Layout is the result of laying text out on the display, and reports how
+// well it fit.
+type Layout struct {
+	// Text is what the display will show, with a line for each row.
+	Text string
+	// Skipped lists the dead cells that text was laid out around, in layout
+	// order. They're left showing whatever they were.
+	Skipped []int
+	// Unsupported lists the characters the display has no flap for. They're
+	// shown as blanks.
+	Unsupported []Unsupported
+	// Substituted lists the text replaced by the display's substitutions
+	// because it couldn't be shown, each once.
+	Substituted []Substitution
+	// Truncated is the number of characters, not counting spaces, that didn't
+	// fit on the display.
+	Truncated int
+	// Dropped is the number of lines, split at newlines, that didn't fit on
+	// the display at all. Their characters are counted in Truncated too.
+	Dropped int
+}
+
+// Unsupported is a character that the display can't show.
+type Unsupported struct {
+	Rune rune
+	Cell int // Where it would have been shown, in layout order.
+}
+
+// Fits reports whether the text fit the display exactly: every character was
+// shown, and shown as itself.
+func (l *Layout) Fits() bool {
+	return len(l.Unsupported) == 0 && l.Truncated == 0 && l.Dropped == 0
+}
+
+// FitError is returned in strict mode for text that doesn't fit the display.
+type FitError struct {
+	Layout *Layout
+}
+
+func (e *FitError) Error() string {
+	var problems []string
+	if n := len(e.Layout.Unsupported); n > 0 {
+		problems = append(problems, fmt.Sprintf("%d unsupported characters", n))
+	}
+	if e.Layout.Truncated > 0 {
+		problems = append(problems, fmt.Sprintf("%d characters truncated", e.Layout.Truncated))
+	}
+	if e.Layout.Dropped > 0 {
+		problems = append(problems, fmt.Sprintf("%d lines dropped", e.Layout.Dropped))
+	}
+	return "text doesn't fit the display: " + strings.Join(problems, ", ")
+}
+
+// LayoutText lays text out for the display, and reports how well it fit. If
+// there are any dead cells, words are placed so that none of them straddles
+// one; otherwise the layout is the same as PrepText's.
+func (d *Display) LayoutText(text string) *Layout {
+	return d.LayoutFormatted(text, nil)
+}
+
+// LayoutFormatted is like LayoutText, but lays the text out as f says. f is
+// ignored if there are dead cells.
+func (d *Display) LayoutFormatted(text string, f *Format) *Layout {
+	return d.layoutMessage(&Message{Text: text, Display: d, Format: f}, nil)
+}
+
+// layoutMessage prepares m, with t as LayoutTextOpts does, and lays it out as
+// m.Format says.
+func (d *Display) layoutMessage(m *Message, t Transformer) *Layout {
+	d.prepare(m, t)
+	layout := d.layoutPrepared(m.Text, m.Format)
+	layout.Substituted = m.Substituted
+	return layout
+}
+
+// layoutPrepared is like LayoutFormatted, for text that's already been
+// through the display's pipeline.
+func (d *Display) layoutPrepared(text string, f *Format) *Layout {
+	g := d.Geometry()
+	var layout *Layout
+	hyphens := 0
+	dead := d.DeadCells()
+	if len(dead) == 0 {
+		var hyph rune
+		if f != nil && f.Hyphenate {
+			hyph = d.hyphenMark()
+		}
+		var lines []string
+		var dropped int
+		lines, dropped, hyphens = layoutBox(text, g.Columns, g.Rows, f, hyph)
+		layout = &Layout{Text: strings.Join(lines, "\n"), Dropped: dropped}
+	} else {
+		layout = d.layoutAround(text, g, dead)
+	}
+
+	// Anything that isn't in the layout, apart from hyphens added to break
+	// words, was truncated.
+	layout.Truncated = countVisible(text) - (countVisible(layout.Text) - hyphens)
+	for row, line := range strings.Split(layout.Text, "\n") {
+		for col, r := range []rune(line) {
+			cell := g.Module(row, col)
+			if _, ok := d.flapIndex(cell, r); !ok {
+				layout.Unsupported = append(layout.Unsupported, Unsupported{Rune: r, Cell: cell})
+			}
+		}
+	}
+	return layout
+}
+
+// countVisible counts the runes in s that aren't spaces or other control
+// characters.
+func countVisible(s string) int {
+	n := 0
+	for _, r := range s {
+		if !unicode.IsSpace(r) && !unicode.IsControl(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// layoutAround lays text out around the dead cells.
+func (d *Display) layoutAround(text string, g Geometry, dead []int) *Layout {
+	cells := make([]rune, g.Cells())
+	for i := range cells {
+		cells[i] = ' '
+	}
+	usable := make([]bool, len(cells))
+	for i := range usable {
+		usable[i] = true
+	}
+	skipped := []int{}
+	for _, c := range dead {
+		if c < len(usable) {
+			usable[c] = false
+			skipped = append(skipped, c)
+		}
+	}
+
+	dropped := fillAround(text, g, usable, cells)
+
+	lines := make([]string, g.Rows)
+	for row := range lines {
+		lines[row] = string(cells[g.Module(row, 0) : g.Module(row, 0)+g.Columns])
+	}
+	return &Layout{Text: strings.Join(lines, "\n"), Skipped: skipped, Dropped: dropped}
+}
+
+// span is a run of usable cells in a row.
+type span struct {
+	row, col, width int
+}
+
+// spans returns the runs of usable cells on the display, in layout order.
+func spans(g Geometry, usable []bool) []span {
+	var s []span
+	for row := 0; row < g.Rows; row++ {
+		start := -1
+		for col := 0; col <= g.Columns; col++ {
+			ok := col < g.Columns && usable[g.Module(row, col)]
+			switch {
+			case ok && start < 0:
+				start = col
+			case !ok && start >= 0:
+				s = append(s, span{row: row, col: start, width: col - start})
+				start = -1
+			}
+		}
+	}
+	return s
+}
+
+// fillAround fills the usable cells with text, a word at a time. A word that
+// doesn't fit in what's left of a span moves on to the next span with room for
+// it, and a word too long for any remaining span is broken across them. A
+// newline in text starts a new row, and other control characters are replaced
+// as replaceControls does. Text that doesn't fit is dropped, and the number of
+// lines that didn't fit at all is returned.
+func fillAround(text string, g Geometry, usable []bool, cells []rune) int {
+	paras := strings.Split(replaceControls(text), "\n")
+	runs := spans(g, usable)
+	if len(runs) == 0 {
+		return countLines(paras)
+	}
+	cur := 0 // The span being filled.
+	pos := 0 // The next free column in the current span.
+
+	put := func(r rune) {
+		s := runs[cur]
+		cells[g.Module(s.row, s.col+pos)] = r
+		pos++
+	}
+
+	placed := false // Whether any of the current line has been placed.
+	// full returns the result when there's no more room, during line i.
+	full := func(i int) int {
+		if placed {
+			i++
+		}
+		return countLines(paras[i:])
+	}
+
+	for i, para := range paras {
+		placed = false
+		if i > 0 {
+			// Start the next row.
+			row := runs[cur].row
+			for cur < len(runs) && runs[cur].row == row {
+				cur++
+			}
+			pos = 0
+			if cur == len(runs) {
+				return full(i)
+			}
+		}
+		for _, word := range strings.Fields(para) {
+			w := []rune(word)
+			sep := 0
+			if pos > 0 {
+				sep = 1
+			}
+			if sep+len(w) > runs[cur].width-pos {
+				// Find a span the word fits in. If there isn't one, break
+				// the word, starting it in a fresh span.
+				j := cur + 1
+				for j < len(runs) && runs[j].width < len(w) {
+					j++
+				}
+				switch {
+				case j < len(runs):
+					cur = j
+				case pos > 0:
+					cur++
+				}
+				pos, sep = 0, 0
+				if cur == len(runs) {
+					return full(i)
+				}
+			}
+			pos += sep
+			for _, r := range w {
+				if pos == runs[cur].width {
+					cur++
+					pos = 0
+					if cur == len(runs) {
+						return full(i)
+					}
+				}
+				put(r)
+				placed = true
+			}
+		}
+	}
+	return 0
+}
+
+// countLines counts the lines that aren't blank.
+func countLines(lines []string) int {
+	n := 0
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			n++
+		}
+	}
+	return n
+}
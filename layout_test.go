@@ -0,0 +1,72 @@
+package flapper
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFillAround(t *testing.T) {
+	g := Geometry{Rows: 2, Columns: 6}
+	tests := []struct {
+		name        string
+		text        string
+		dead        []int
+		want        []string
+		wantDropped int
+	}{
+		{"no dead cells", "hello world", nil, []string{"hello ", "world "}, 0},
+		{"word moves past dead cell", "abc de", []int{2}, []string{"   abc", "de    "}, 0},
+		{"short words fill the gap", "ab cd", []int{2}, []string{"ab cd ", "      "}, 0},
+		{"fully dead row", "hello world", []int{0, 1, 2, 3, 4, 5},
+			[]string{"      ", "hello "}, 0},
+		{"newline into dead row", "a\nb\nc", []int{6, 7, 8, 9, 10, 11},
+			[]string{"a     ", "      "}, 2},
+		{"longer than any span", "abcdefgh", []int{3},
+			[]string{"abc de", "fgh   "}, 0},
+		{"long word starts a fresh span", "x abcdefgh", []int{3},
+			[]string{"x   ab", "cdefgh"}, 0},
+		{"all dead", "hi\nthere", []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+			[]string{"      ", "      "}, 2},
+		{"control characters", "x\fy\ta b\x1b", []int{11},
+			[]string{"x     ", "y a b "}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cells := []rune(strings.Repeat(" ", g.Cells()))
+			usable := make([]bool, g.Cells())
+			for i := range usable {
+				usable[i] = true
+			}
+			for _, c := range tt.dead {
+				usable[c] = false
+			}
+			dropped := fillAround(tt.text, g, usable, cells)
+			got := []string{string(cells[:6]), string(cells[6:])}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fillAround(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if dropped != tt.wantDropped {
+				t.Errorf("fillAround(%q) dropped %d lines, want %d", tt.text, dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestLayoutAroundDeadCells(t *testing.T) {
+	d := newDisplay()
+	if err := d.SetDeadCells(3, 12); err != nil {
+		t.Fatal(err)
+	}
+	l := d.LayoutText("abc defgh\x00 jklmnopqrstuvwxyz")
+	want := "abc defgh   \n jklmnopqrst"
+	if l.Text != want {
+		t.Errorf("Text = %q, want %q", l.Text, want)
+	}
+	if !reflect.DeepEqual(l.Skipped, []int{3, 12}) {
+		t.Errorf("Skipped = %v, want [3 12]", l.Skipped)
+	}
+	if l.Truncated != 6 {
+		t.Errorf("Truncated = %d, want 6", l.Truncated)
+	}
+}
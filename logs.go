@@ -0,0 +1,82 @@
+package flapper
+
+import (
+	"time"
+)
+
+// defaultLogCapacity is the number of firmware log lines kept by a Display.
+const defaultLogCapacity = 500
+
+// LogEntry is a log message received from the controller firmware.
+type LogEntry struct {
+	Seq  uint64    // Increases by one with each message received.
+	Time time.Time // When the message was received.
+	Msg  string
+}
+
+// logRing holds the most recent firmware log messages.
+type logRing struct {
+	entries []LogEntry
+	next    int // where the next entry goes, once entries is full
+	seq     uint64
+}
+
+func (r *logRing) add(size int, t time.Time, msg string) {
+	r.seq++
+	e := LogEntry{Seq: r.seq, Time: t, Msg: msg}
+	if len(r.entries) < size {
+		r.entries = append(r.entries, e)
+		return
+	}
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+}
+
+// all returns the entries, oldest first.
+func (r *logRing) all() []LogEntry {
+	logs := make([]LogEntry, 0, len(r.entries))
+	logs = append(logs, r.entries[r.next:]...)
+	return append(logs, r.entries[:r.next]...)
+}
+
+// Logs returns the firmware log messages that have been received, oldest
+// first. Only the most recent messages are kept; see SetLogCapacity.
+func (d *Display) Logs() []LogEntry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.logs.all()
+}
+
+// LogsSince returns the firmware log messages received after t.
+func (d *Display) LogsSince(t time.Time) []LogEntry {
+	logs := d.Logs()
+	for i, e := range logs {
+		if e.Time.After(t) {
+			return logs[i:]
+		}
+	}
+	return nil
+}
+
+// SetLogCapacity changes the number of firmware log messages kept. Shrinking
+// it keeps the most recent messages.
+func (d *Display) SetLogCapacity(n int) {
+	if n < 1 {
+		n = 1
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	logs := d.logs.all()
+	if len(logs) > n {
+		logs = logs[len(logs)-n:]
+	}
+	d.logs.entries = logs
+	d.logs.next = 0
+	d.logCap = n
+}
+
+func (d *Display) addLog(msg string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.logs.add(d.logCap, time.Now(), msg)
+}
@@ -0,0 +1,114 @@
+package flapper
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var logEpoch = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// logDisplay returns a display holding n log messages, "1" to "n", received
+// a second apart, and keeping at most capacity of them.
+func logDisplay(capacity, n int) *Display {
+	d := &Display{logCap: capacity}
+	for i := 1; i <= n; i++ {
+		d.logs.add(d.logCap, logEpoch.Add(time.Duration(i)*time.Second), itoa(i))
+	}
+	return d
+}
+
+func itoa(i int) string {
+	return fmt.Sprintf("%02d", i)
+}
+
+// logMsgs returns the messages of logs, with their sequence numbers.
+func logMsgs(logs []LogEntry) []string {
+	var msgs []string
+	for _, e := range logs {
+		msgs = append(msgs, e.Msg)
+		if e.Msg != itoa(int(e.Seq)) {
+			msgs = append(msgs, "bad seq")
+		}
+	}
+	return msgs
+}
+
+func TestLogRing(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		n        int
+		want     []string
+	}{
+		{"empty", 3, 0, nil},
+		{"not full", 3, 2, []string{"01", "02"}},
+		{"full", 3, 3, []string{"01", "02", "03"}},
+		{"wrapped", 3, 4, []string{"02", "03", "04"}},
+		{"wrapped twice", 3, 8, []string{"06", "07", "08"}},
+		{"one", 1, 5, []string{"05"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := logMsgs(logDisplay(tt.capacity, tt.n).Logs())
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Logs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetLogCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity int
+		n        int
+		resize   int
+		more     int // Messages added after resizing.
+		want     []string
+	}{
+		{"shrink", 4, 4, 2, 0, []string{"03", "04"}},
+		{"shrink wrapped", 3, 5, 2, 0, []string{"04", "05"}},
+		{"shrink then add", 3, 5, 2, 1, []string{"05", "06"}},
+		{"grow wrapped", 3, 5, 5, 0, []string{"03", "04", "05"}},
+		{"grow then add", 3, 5, 4, 2, []string{"04", "05", "06", "07"}},
+		{"at least one", 3, 3, 0, 1, []string{"04"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := logDisplay(tt.capacity, tt.n)
+			d.SetLogCapacity(tt.resize)
+			for i := tt.n + 1; i <= tt.n+tt.more; i++ {
+				d.logs.add(d.logCap, logEpoch.Add(time.Duration(i)*time.Second), itoa(i))
+			}
+			got := logMsgs(d.Logs())
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Logs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogsSince(t *testing.T) {
+	tests := []struct {
+		name  string
+		since time.Duration // After logEpoch.
+		want  []string
+	}{
+		{"before all", 0, []string{"03", "04", "05"}},
+		{"dropped", 2 * time.Second, []string{"03", "04", "05"}},
+		{"exclusive", 3 * time.Second, []string{"04", "05"}},
+		{"between", 4*time.Second + time.Millisecond, []string{"05"}},
+		{"after all", 5 * time.Second, nil},
+	}
+	d := logDisplay(3, 5)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := logMsgs(d.LogsSince(logEpoch.Add(tt.since)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LogsSince(+%v) = %q, want %q", tt.since, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package flapper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/trapgate/flapper/proto"
+)
+
+// moduleMap checks the configured module map against the module count, and
+// returns it along with its inverse. Both are nil if there's no map.
+func (cfg *Config) moduleMap(modules int) (toPhys, toLog []int, err error) {
+	if len(cfg.ModuleMap) == 0 {
+		return nil, nil, nil
+	}
+	if len(cfg.ModuleMap) > modules {
+		return nil, nil, fmt.Errorf("module map has %d entries, but the display has %d modules",
+			len(cfg.ModuleMap), modules)
+	}
+	// The map has to be a reordering of the modules it covers, so that the
+	// modules after it can keep their places.
+	toLog = make([]int, len(cfg.ModuleMap))
+	for i := range toLog {
+		toLog[i] = -1
+	}
+	for i, p := range cfg.ModuleMap {
+		if p < 0 || p >= len(cfg.ModuleMap) {
+			return nil, nil, fmt.Errorf("module map entry %d is %d; it must be from 0 to %d",
+				i, p, len(cfg.ModuleMap)-1)
+		}
+		if toLog[p] >= 0 {
+			return nil, nil, fmt.Errorf("module %d appears more than once in the module map", p)
+		}
+		toLog[p] = i
+	}
+	return append([]int(nil), cfg.ModuleMap...), toLog, nil
+}
+
+// physical returns the position in the chain of the module at logical index
+// i. d.mu must be held.
+func (d *Display) physical(i int) int {
+	if i < len(d.toPhys) {
+		return d.toPhys[i]
+	}
+	return i
+}
+
+// logical returns the logical index of the module at position p in the
+// chain. d.mu must be held.
+func (d *Display) logical(p int) int {
+	if p < len(d.toLog) {
+		return d.toLog[p]
+	}
+	return p
+}
+
+// logicalOrder returns the modules of a state report rearranged into logical
+// order.
+func (d *Display) logicalOrder(modules []*proto.SplitflapState_ModuleState) []*proto.SplitflapState_ModuleState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inLogicalOrder(modules)
+}
+
+// inLogicalOrder is like logicalOrder. d.mu must be held.
+func (d *Display) inLogicalOrder(modules []*proto.SplitflapState_ModuleState) []*proto.SplitflapState_ModuleState {
+	out := make([]*proto.SplitflapState_ModuleState, len(modules))
+	for p, m := range modules {
+		i := d.logical(p)
+		if i >= len(out) {
+			i = p
+		}
+		out[i] = m
+	}
+	return out
+}
+
+// physicalModules converts logical module indexes to chain positions.
+func (d *Display) physicalModules(modules []int) []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]int, len(modules))
+	for i, m := range modules {
+		out[i] = d.physical(m)
+	}
+	return out
+}
+
+// logicalModules converts chain positions to logical module indexes.
+func (d *Display) logicalModules(modules []int) []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]int, len(modules))
+	for i, p := range modules {
+		out[i] = d.logical(p)
+	}
+	return out
+}
+
+// Identify sends each module to a digit of its position in the chain,
+// ignoring the module map, so that the wiring of the display can be worked
+// out. The last digit is shown, or the tens digit if tens is set.
+func (d *Display) Identify(tens bool) error {
+	return d.IdentifyContext(context.Background(), tens)
+}
+
+// IdentifyContext is like Identify, but gives up when ctx is done.
+func (d *Display) IdentifyContext(ctx context.Context, tens bool) error {
+	mc := make([]*proto.SplitflapCommand_ModuleCommand, d.Modules())
+	for i := range mc {
+		n := i
+		if tens {
+			n /= 10
+		}
+		digit := rune('0' + n%10)
+		flap, ok := d.flapIndex(d.logicalModules([]int{i})[0], digit)
+		if !ok {
+			return fmt.Errorf("the display has no flap for %q", digit)
+		}
+		mc[i] = &proto.SplitflapCommand_ModuleCommand{
+			Action: proto.SplitflapCommand_ModuleCommand_GO_TO_FLAP,
+			Param:  uint32(flap),
+		}
+	}
+	return d.sendModuleCommand(ctx, mc)
+}
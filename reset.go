@@ -0,0 +1,204 @@
+package flapper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/trapgate/flapper/proto"
+)
+
+const (
+	// homeTimeout is how long ResetModules waits for modules to find home.
+	homeTimeout = 30 * time.Second
+	// statePollInterval is how often the display is asked for its state while
+	// waiting for modules to finish moving.
+	statePollInterval = time.Second
+)
+
+// HomeError is returned by ResetModules when some of the modules didn't find
+// their home position.
+type HomeError struct {
+	Modules []int // The modules that failed to home.
+	Err     error // Why waiting stopped early, if it did.
+}
+
+func (e *HomeError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("modules %v failed to home: %v", e.Modules, e.Err)
+	}
+	return fmt.Sprintf("modules %v failed to home", e.Modules)
+}
+
+func (e *HomeError) Unwrap() error {
+	return e.Err
+}
+
+// ResetModules resets the given modules, clearing their error counters and
+// making them find their home position again. The other modules are left
+// alone. Modules are numbered in layout order, with the module map applied. It
+// waits until the modules have homed, and returns a *HomeError listing any
+// that didn't.
+func (d *Display) ResetModules(modules ...int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), homeTimeout)
+	defer cancel()
+	return d.ResetModulesContext(ctx, modules...)
+}
+
+// ResetModulesContext is like ResetModules, but gives up waiting when ctx is
+// done. Modules still homing at that point are reported as failed.
+func (d *Display) ResetModulesContext(ctx context.Context, modules ...int) error {
+	mc := make([]*proto.SplitflapCommand_ModuleCommand, d.Modules())
+	for i := range mc {
+		mc[i] = &proto.SplitflapCommand_ModuleCommand{
+			Action: proto.SplitflapCommand_ModuleCommand_NO_OP,
+		}
+	}
+	for _, m := range modules {
+		if m < 0 || m >= len(mc) {
+			return fmt.Errorf("module %d out of range; the display has %d modules", m, len(mc))
+		}
+	}
+	phys := d.physicalModules(modules)
+	for _, p := range phys {
+		if p >= len(mc) {
+			continue
+		}
+		mc[p].Action = proto.SplitflapCommand_ModuleCommand_RESET_AND_HOME
+	}
+
+	msg := &proto.ToSplitflap{
+		Payload: &proto.ToSplitflap_SplitflapCommand{
+			SplitflapCommand: &proto.SplitflapCommand{
+				Modules: mc,
+			},
+		},
+	}
+	d.clearTargets(mc)
+	failed, err := d.sendAndWait(ctx, msg, phys, homed)
+	if len(failed) > 0 {
+		failed = d.logicalModules(failed)
+		sort.Ints(failed)
+		return &HomeError{Modules: failed, Err: err}
+	}
+	return err
+}
+
+// ResetAll resets every module on the display.
+func (d *Display) ResetAll() error {
+	return d.ResetModules(d.allModules()...)
+}
+
+// ResetAllContext is like ResetAll, but gives up waiting when ctx is done.
+func (d *Display) ResetAllContext(ctx context.Context) error {
+	return d.ResetModulesContext(ctx, d.allModules()...)
+}
+
+func (d *Display) allModules() []int {
+	all := make([]int, d.Modules())
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// moduleResult is what a waitFunc decides about a module from a state report.
+type moduleResult int
+
+const (
+	moduleBusy moduleResult = iota
+	moduleDone
+	moduleFailed
+)
+
+// waitFunc examines a module's reported state while waiting for a command to
+// finish.
+type waitFunc func(module int, m *proto.SplitflapState_ModuleState) moduleResult
+
+// homed waits for a module to finish looking for home.
+func homed(_ int, m *proto.SplitflapState_ModuleState) moduleResult {
+	switch m.GetState() {
+	case proto.SplitflapState_ModuleState_NORMAL:
+		if m.GetMoving() {
+			return moduleBusy
+		}
+		return moduleDone
+	case proto.SplitflapState_ModuleState_LOOK_FOR_HOME:
+		return moduleBusy
+	default:
+		return moduleFailed
+	}
+}
+
+// sendAndWait sends msg, then watches the state reports that follow its ack
+// until wait has decided about every one of modules, which are given by
+// their positions in the chain. It returns the modules
+// that failed, including any still busy when ctx is done; in that case the
+// context's error is returned too.
+func (d *Display) sendAndWait(ctx context.Context, msg *proto.ToSplitflap, modules []int, wait waitFunc) ([]int, error) {
+	// Subscribe first, so that no reports are missed.
+	sub := d.Subscribe(StateMessages|AckMessages, 64)
+	defer sub.Close()
+
+	err := d.send(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[int]bool)
+	for _, m := range modules {
+		pending[m] = true
+	}
+	var failed []int
+	// Reports queued before the ack may predate the command; skip them.
+	acked := false
+
+	poll := time.NewTicker(statePollInterval)
+	defer poll.Stop()
+	for len(pending) > 0 {
+		select {
+		case fm, ok := <-sub.C:
+			if !ok {
+				return nil, ErrClosed
+			}
+			if ack := fm.GetAck(); ack != nil {
+				acked = acked || ack.GetNonce() == msg.GetNonce()
+				continue
+			}
+			// If messages were dropped the ack may have been one of them.
+			if !acked && sub.Dropped() == 0 {
+				continue
+			}
+			state := fm.GetSplitflapState()
+			for m := range pending {
+				if m >= len(state.GetModules()) {
+					continue
+				}
+				switch wait(m, state.GetModules()[m]) {
+				case moduleDone:
+					delete(pending, m)
+				case moduleFailed:
+					delete(pending, m)
+					failed = append(failed, m)
+				}
+			}
+		case <-poll.C:
+			// Reports normally arrive on their own while modules move, but
+			// ask in case one was missed. The report is read above, so
+			// don't wait long for the ack.
+			pctx, cancel := context.WithTimeout(ctx, statePollInterval)
+			d.readStatus(pctx)
+			cancel()
+		case <-ctx.Done():
+			for m := range pending {
+				failed = append(failed, m)
+			}
+			sort.Ints(failed)
+			return failed, ctx.Err()
+		}
+	}
+
+	sort.Ints(failed)
+	return failed, nil
+}
@@ -0,0 +1,158 @@
+package flapper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// stepSettleTimeout is the longest ShowPages and ShowMarquee wait for the
+// flaps of one page or step to arrive before going on to the next.
+const stepSettleTimeout = 30 * time.Second
+
+// Pages lays text out in as many displays' worth of rows as it takes to show
+// all of it, formatted as f says. Each page has a line for each row, padded
+// to the width of the display, like PrepText. A form feed in the text starts
+// a new page.
+func (d *Display) Pages(text string, f *Format) []string {
+	return d.pages(d.normalize(text), f)
+}
+
+// pages is like Pages, for text that's already been through the display's
+// pipeline.
+func (d *Display) pages(text string, f *Format) []string {
+	g := d.Geometry()
+	var hyph rune
+	if f != nil && f.Hyphenate {
+		hyph = d.hyphenMark()
+	}
+	var pages []string
+	for _, page := range layoutPages(text, g.Columns, g.Rows, f, hyph) {
+		pages = append(pages, strings.Join(page, "\n"))
+	}
+	return pages
+}
+
+// ShowPages shows text a page at a time, as laid out by Pages, leaving each
+// page up for dwell once its flaps have arrived. It returns when the last
+// page has been sent, without waiting for it to arrive. Dead cells aren't
+// taken into account when the text is split into pages, so pages may not
+// fit around them. The layout of each page shown is returned. In strict mode,
+// every page is checked before any is shown, and the first that doesn't fit
+// is returned in a *FitError.
+func (d *Display) ShowPages(ctx context.Context, text string, dwell time.Duration, opts *TextOptions) ([]*Layout, error) {
+	if opts == nil {
+		opts = &TextOptions{}
+	}
+	// The text is prepared once, and each page is laid out as it is.
+	m := &Message{Text: text, Display: d, Format: opts.Format}
+	d.prepare(m, opts.Transform)
+	var layouts []*Layout
+	for _, page := range d.pages(m.Text, opts.Format) {
+		layout := d.layoutPrepared(page, nil)
+		layout.Substituted = m.Substituted
+		if opts.Strict && !layout.Fits() {
+			return nil, &FitError{Layout: layout}
+		}
+		layouts = append(layouts, layout)
+	}
+	for i, layout := range layouts {
+		if _, err := d.showLayout(ctx, layout, opts); err != nil {
+			return layouts[:i], err
+		}
+		if i+1 == len(layouts) {
+			break
+		}
+		if err := d.dwell(ctx, dwell); err != nil {
+			return layouts[:i+1], err
+		}
+	}
+	return layouts, nil
+}
+
+// MarqueeFrames returns what a row of the display shows at each step as text
+// scrolls across it from right to left, one column per step. The text starts
+// just off the right edge of the row and scrolls until it's gone off the
+// left, so the last frame is blank. Newlines in the text are treated as
+// spaces.
+func (d *Display) MarqueeFrames(text string) []string {
+	return d.marqueeFrames(d.normalize(text))
+}
+
+// marqueeFrames is like MarqueeFrames, for text that's already been through
+// the display's pipeline.
+func (d *Display) marqueeFrames(text string) []string {
+	width := d.Geometry().Columns
+	text = strings.ReplaceAll(text, "\n", " ")
+	track := []rune(strings.Repeat(" ", width) + strings.TrimSpace(text) +
+		strings.Repeat(" ", width))
+	var frames []string
+	for start := 1; start+width <= len(track); start++ {
+		frames = append(frames, string(track[start:start+width]))
+	}
+	return frames
+}
+
+// ShowMarquee scrolls text across a row of the display, as MarqueeFrames
+// describes, leaving the rest of the display alone. Each step waits for the
+// flaps of the one before to arrive, then for step. Only the cells of a frame
+// that change are moved, unless opts says otherwise; opts.Format and
+// opts.Strict aren't used.
+func (d *Display) ShowMarquee(ctx context.Context, text string, row int, step time.Duration, opts *TextOptions) error {
+	g := d.Geometry()
+	if row < 0 || row >= g.Rows {
+		return fmt.Errorf("row %d out of range; the display has %d rows", row, g.Rows)
+	}
+	var t Transformer
+	if opts != nil {
+		t = opts.Transform
+	}
+	dead := d.DeadCells()
+	frames := d.marqueeFrames(d.Transform(text, t).Text)
+	for i, frame := range frames {
+		want := make(map[int]rune)
+		for col, r := range []rune(frame) {
+			want[g.Module(row, col)] = r
+		}
+		for _, c := range dead {
+			delete(want, c)
+		}
+		if err := d.sendCells(ctx, want, opts); err != nil {
+			return err
+		}
+		if i+1 == len(frames) {
+			break
+		}
+		if err := d.dwell(ctx, step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dwell waits for the flaps to arrive, then for another delay. Modules that
+// don't arrive are ignored, so one stuck module doesn't stop the rest of the
+// text being shown.
+func (d *Display) dwell(ctx context.Context, delay time.Duration) error {
+	sctx, cancel := context.WithTimeout(ctx, stepSettleTimeout)
+	err := d.WaitSettled(sctx)
+	cancel()
+	var settleErr *SettleError
+	if errors.As(err, &settleErr) && ctx.Err() == nil {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,128 @@
+package flapper_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/trapgate/flapper"
+)
+
+func TestPages(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		f    *flapper.Format
+		want []string
+	}{
+		{"one page", "hello world", nil,
+			[]string{pad("hello world", 12) + "\n" + pad("", 12)}},
+		{"empty", "", nil,
+			[]string{pad("", 12) + "\n" + pad("", 12)}},
+		{"wrapped", "the quick brown fox jumps over the lazy dog", nil, []string{
+			pad("the quick", 12) + "\n" + pad("brown fox", 12),
+			pad("jumps over", 12) + "\n" + pad("the lazy dog", 12),
+		}},
+		{"form feed", "one\ftwo", nil, []string{
+			pad("one", 12) + "\n" + pad("", 12),
+			pad("two", 12) + "\n" + pad("", 12),
+		}},
+		{"lines", "a\nb\nc", nil, []string{
+			pad("a", 12) + "\n" + pad("b", 12),
+			pad("c", 12) + "\n" + pad("", 12),
+		}},
+		{"prepared", "Café & Co", nil,
+			[]string{pad("cafe and co", 12) + "\n" + pad("", 12)}},
+		{"centered", "hi\fthere", &flapper.Format{Align: []flapper.Align{flapper.AlignCenter}}, []string{
+			pad("     hi", 12) + "\n" + pad("", 12),
+			pad("   there", 12) + "\n" + pad("", 12),
+		}},
+	}
+	d, _ := newTestDisplay(t, 24)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.Pages(tt.text, tt.f); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Pages(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarqueeFrames(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"empty", "", []string{"    ", "    ", "    ", "    "}},
+		{"short", "hi", []string{"   h", "  hi", " hi ", "hi  ", "i   ", "    "}},
+		{"trimmed", "  hi\n", []string{"   h", "  hi", " hi ", "hi  ", "i   ", "    "}},
+		{"newlines", "a\nb", []string{"   a", "  a ", " a b", "a b ", " b  ", "b   ", "    "}},
+		{"prepared", "É&", []string{"   e", "  ea", " ean", "eand", "and ", "nd  ", "d   ", "    "}},
+		{"wider than the row", "abcdef", []string{
+			"   a", "  ab", " abc", "abcd", "bcde", "cdef", "def ", "ef  ", "f   ", "    ",
+		}},
+	}
+	d, _ := newTestDisplay(t, 4)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.MarqueeFrames(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MarqueeFrames(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// countingTransformer counts the times it's run.
+type countingTransformer struct {
+	runs int
+}
+
+func (c *countingTransformer) Transform(m *flapper.Message) {
+	c.runs++
+}
+
+func TestShowPagesPreparesOnce(t *testing.T) {
+	d, c := newTestDisplay(t, 24)
+	pipeline := &countingTransformer{}
+	d.SetPipeline(flapper.Pipeline{pipeline, flapper.DefaultPipeline})
+	transform := &countingTransformer{}
+	opts := &flapper.TextOptions{Transform: transform}
+	layouts, err := d.ShowPages(context.Background(), "rock & roll\fpage two", 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transform.runs != 1 || pipeline.runs != 1 {
+		t.Errorf("ran the transform %d times and the pipeline %d times, want once each",
+			transform.runs, pipeline.runs)
+	}
+	if len(layouts) != 2 {
+		t.Fatalf("ShowPages returned %d layouts, want 2", len(layouts))
+	}
+	if got, want := layouts[0].Text, pad("rock and", 12)+"\n"+pad("roll", 12); got != want {
+		t.Errorf("first page is %q, want %q", got, want)
+	}
+	want := []flapper.Substitution{{From: "&", To: "and"}}
+	if !reflect.DeepEqual(layouts[0].Substituted, want) {
+		t.Errorf("first page substituted %v, want %v", layouts[0].Substituted, want)
+	}
+	if got, want := shown(c), pad("page two", 24); got != want {
+		t.Errorf("controller shows %q, want %q", got, want)
+	}
+}
+
+func TestShowMarqueePreparesOnce(t *testing.T) {
+	d, c := newTestDisplay(t, 4)
+	pipeline := &countingTransformer{}
+	d.SetPipeline(flapper.Pipeline{pipeline, flapper.DefaultPipeline})
+	if err := d.ShowMarquee(context.Background(), "ab", 0, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if pipeline.runs != 1 {
+		t.Errorf("ran the pipeline %d times, want once", pipeline.runs)
+	}
+	if got := shown(c); strings.TrimSpace(got) != "" {
+		t.Errorf("controller shows %q, want the last frame, which is blank", got)
+	}
+}
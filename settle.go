@@ -0,0 +1,170 @@
+package flapper
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/trapgate/flapper/proto"
+)
+
+// SettleError is returned by WaitSettled when some modules didn't reach
+// their target flap.
+type SettleError struct {
+	Modules []int // The modules that didn't arrive.
+	Err     error // Why waiting stopped early, if it did.
+}
+
+func (e *SettleError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("modules %v did not settle: %v", e.Modules, e.Err)
+	}
+	return fmt.Sprintf("modules %v did not settle", e.Modules)
+}
+
+func (e *SettleError) Unwrap() error {
+	return e.Err
+}
+
+// WaitSettled blocks until every module has stopped moving and is showing the
+// flap it was last sent to. Modules that report an error, or are still moving
+// when ctx is done, are listed in the returned *SettleError.
+func (d *Display) WaitSettled(ctx context.Context) error {
+	d.mu.Lock()
+	targets := append([]int(nil), d.sent...)
+	d.mu.Unlock()
+
+	settled := func(i int, m *proto.SplitflapState_ModuleState) moduleResult {
+		switch m.GetState() {
+		case proto.SplitflapState_ModuleState_NORMAL:
+		case proto.SplitflapState_ModuleState_LOOK_FOR_HOME:
+			return moduleBusy
+		default:
+			return moduleFailed
+		}
+		// A module that's stopped short of its target may just not have been
+		// started yet, if max_moving or start_delay_millis are set.
+		if m.GetMoving() || (i < len(targets) && targets[i] >= 0 &&
+			int(m.GetFlapIndex()) != targets[i]) {
+			return moduleBusy
+		}
+		return moduleDone
+	}
+
+	// Requesting the state gets a fresh report to start from.
+	msg := &proto.ToSplitflap{
+		Payload: &proto.ToSplitflap_RequestState{
+			RequestState: &proto.RequestState{},
+		},
+	}
+	failed, err := d.sendAndWait(ctx, msg, d.allModules(), settled)
+	if len(failed) > 0 {
+		failed = d.logicalModules(failed)
+		sort.Ints(failed)
+		return &SettleError{Modules: failed, Err: err}
+	}
+	return err
+}
+
+// SetTextAndWait is like SetTextContext, but doesn't return until the flaps
+// have arrived, as WaitSettled.
+func (d *Display) SetTextAndWait(ctx context.Context, text string) error {
+	err := d.SetTextContext(ctx, text)
+	if err != nil {
+		return err
+	}
+	return d.WaitSettled(ctx)
+}
+
+// sendModuleCommand sends a command to the modules, given by position in the
+// chain, and records the flaps they were sent to as soon as it's acked, so
+// that the state reports that follow are compared with them.
+func (d *Display) sendModuleCommand(ctx context.Context, mc []*proto.SplitflapCommand_ModuleCommand) error {
+	d.clearTargets(mc)
+	msg := &proto.ToSplitflap{
+		Payload: &proto.ToSplitflap_SplitflapCommand{
+			SplitflapCommand: &proto.SplitflapCommand{
+				Modules: mc,
+			},
+		},
+	}
+	err := d.sendAcked(ctx, msg, func() { d.setTargets(mc) })
+	if err != nil {
+		d.clearTargets(mc)
+	}
+	return err
+}
+
+// setTargets records the flaps modules have been sent to, for WaitSettled and
+// for working out which modules need to move. It must only be called once
+// the command has been acked. Entries of mc that are NO_OPs leave the target
+// unchanged.
+func (d *Display) setTargets(mc []*proto.SplitflapCommand_ModuleCommand) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for len(d.targets) < len(mc) {
+		d.targets = append(d.targets, moduleTarget{flap: -1})
+	}
+	for len(d.sent) < len(mc) {
+		d.sent = append(d.sent, -1)
+	}
+	for i, c := range mc {
+		switch c.GetAction() {
+		case proto.SplitflapCommand_ModuleCommand_GO_TO_FLAP:
+			d.targets[i] = moduleTarget{flap: int(c.GetParam())}
+			d.sent[i] = d.targets[i].flap
+		case proto.SplitflapCommand_ModuleCommand_RESET_AND_HOME:
+			// Where a module ends up after homing is only known once the
+			// controller reports it.
+			d.targets[i] = moduleTarget{flap: -1}
+			d.sent[i] = -1
+		}
+	}
+}
+
+// clearTargets forgets the targets of the modules mc moves. It's called
+// before sending a command, since until it's acked, and if it fails, there's
+// no knowing whether the modules will move.
+func (d *Display) clearTargets(mc []*proto.SplitflapCommand_ModuleCommand) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, c := range mc {
+		if c.GetAction() == proto.SplitflapCommand_ModuleCommand_NO_OP {
+			continue
+		}
+		if i < len(d.targets) {
+			d.targets[i] = moduleTarget{flap: -1}
+		}
+		if i < len(d.sent) {
+			d.sent[i] = -1
+		}
+	}
+}
+
+// moduleTarget is the flap a module was sent to.
+type moduleTarget struct {
+	flap int  // -1 if it isn't known
+	seen bool // The module has been reported moving, or at flap, since.
+}
+
+// checkTargets forgets the targets of modules that have failed, or that have
+// stopped somewhere else after setting off, according to a state report, so
+// that what the controller reports is believed instead. A module that hasn't
+// set off yet keeps its target, since the controller may be holding it back
+// until others have finished moving. d.mu must be held.
+func (d *Display) checkTargets(st *proto.SplitflapState) {
+	for p, m := range st.GetModules() {
+		if p >= len(d.targets) || d.targets[p].flap < 0 {
+			continue
+		}
+		t := &d.targets[p]
+		switch {
+		case m.GetState() != proto.SplitflapState_ModuleState_NORMAL:
+			t.flap = -1
+		case m.GetMoving() || int(m.GetFlapIndex()) == t.flap:
+			t.seen = true
+		case t.seen:
+			t.flap = -1
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package flapper
+
+import (
+	"sync/atomic"
+
+	"github.com/trapgate/flapper/proto"
+)
+
+// defaultSubscriptionBuffer is used when Subscribe is called without a buffer
+// size.
+const defaultSubscriptionBuffer = 16
+
+// MessageType is a set of FromSplitflap payload types, used to choose which
+// messages a Subscription receives.
+type MessageType uint
+
+const (
+	StateMessages MessageType = 1 << iota
+	LogMessages
+	AckMessages
+	SupervisorMessages
+
+	AllMessages = StateMessages | LogMessages | AckMessages | SupervisorMessages
+)
+
+func messageType(msg *proto.FromSplitflap) MessageType {
+	switch msg.Payload.(type) {
+	case *proto.FromSplitflap_SplitflapState:
+		return StateMessages
+	case *proto.FromSplitflap_Log:
+		return LogMessages
+	case *proto.FromSplitflap_Ack:
+		return AckMessages
+	case *proto.FromSplitflap_SupervisorState:
+		return SupervisorMessages
+	default:
+		return 0
+	}
+}
+
+// Subscription delivers messages from the controller. Messages are sent on C
+// without ever blocking the display: if the subscriber falls behind and its
+// buffer fills, the oldest buffered message is dropped to make room. C is
+// closed when the subscription or the display is closed.
+type Subscription struct {
+	C <-chan *proto.FromSplitflap
+
+	d       *Display
+	ch      chan *proto.FromSplitflap
+	filter  MessageType
+	dropped uint64
+}
+
+// Subscribe returns a Subscription that receives every message from the
+// controller matching filter. buffer is the number of messages that can be
+// queued for the subscriber; if it's zero or less a small default is used.
+// The messages are shared with other subscribers, and must not be modified.
+func (d *Display) Subscribe(filter MessageType, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = defaultSubscriptionBuffer
+	}
+	ch := make(chan *proto.FromSplitflap, buffer)
+	s := &Subscription{
+		C:      ch,
+		d:      d,
+		ch:     ch,
+		filter: filter,
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.subs == nil {
+		// The display has already shut down.
+		close(ch)
+		return s
+	}
+	d.subs[s] = struct{}{}
+	return s
+}
+
+// Close stops the subscription and closes C.
+func (s *Subscription) Close() {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if _, ok := s.d.subs[s]; ok {
+		delete(s.d.subs, s)
+		close(s.ch)
+	}
+}
+
+// Dropped returns the number of messages dropped because the subscriber's
+// buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// publish sends msg to every interested subscriber.
+func (d *Display) publish(msg *proto.FromSplitflap) {
+	t := messageType(msg)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for s := range d.subs {
+		if s.filter&t == 0 {
+			continue
+		}
+		select {
+		case s.ch <- msg:
+			continue
+		default:
+		}
+		// Full; drop the oldest message to make room. publish is only called
+		// from one goroutine, so once there's room the send can't fail.
+		select {
+		case <-s.ch:
+		default:
+		}
+		s.ch <- msg
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// closeSubs closes every subscription, once the display has shut down.
+func (d *Display) closeSubs() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for s := range d.subs {
+		close(s.ch)
+	}
+	d.subs = nil
+}
@@ -0,0 +1,168 @@
+package flapper
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultSubstitutions replace characters that displays often have no flaps
+// for with text they can show. Emoji are replaced with their short names
+// from CLDR. They're only used for characters the display can't show, and
+// can be changed with Config.Substitutions.
+var DefaultSubstitutions = map[string]string{
+	"&":  "and",
+	"%":  " pct",
+	":":  ".",
+	";":  ",",
+	"!":  ".",
+	"?":  ".",
+	"\"": "'",
+	"‘":  "'",
+	"’":  "'",
+	"“":  "'",
+	"”":  "'",
+	"…":  "...",
+	"-":  " ",
+
+	"😀": "grinning face",
+	"😂": "face with tears of joy",
+	"🙂": "slightly smiling face",
+	"😊": "smiling face with smiling eyes",
+	"😉": "winking face",
+	"😍": "smiling face with heart eyes",
+	"😎": "smiling face with sunglasses",
+	"🤔": "thinking face",
+	"😐": "neutral face",
+	"😢": "crying face",
+	"😭": "loudly crying face",
+	"😡": "enraged face",
+	"😱": "face screaming in fear",
+	"👍": "thumbs up",
+	"👎": "thumbs down",
+	"👋": "waving hand",
+	"👏": "clapping hands",
+	"🙏": "folded hands",
+	"❤": "red heart",
+	"💔": "broken heart",
+	"💯": "hundred points",
+	"🔥": "fire",
+	"⭐": "star",
+	"✨": "sparkles",
+	"🎉": "party popper",
+	"🎂": "birthday cake",
+	"🎄": "christmas tree",
+	"🎁": "wrapped gift",
+	"☀": "sun",
+	"⛅": "sun behind cloud",
+	"☁": "cloud",
+	"🌧": "cloud with rain",
+	"⛈": "cloud with lightning and rain",
+	"❄": "snowflake",
+	"⚡": "high voltage",
+	"🌈": "rainbow",
+	"🌙": "crescent moon",
+	"🌊": "water wave",
+	"🌋": "volcano",
+	"🌍": "globe showing europe africa",
+	"🌎": "globe showing americas",
+	"🌏": "globe showing asia australia",
+	"☕": "hot beverage",
+	"🍕": "pizza",
+	"🍺": "beer mug",
+	"🍷": "wine glass",
+	"🐶": "dog face",
+	"🐱": "cat face",
+	"🚀": "rocket",
+	"🚗": "automobile",
+	"✈": "airplane",
+	"🏠": "house",
+	"⏰": "alarm clock",
+	"✅": "check mark button",
+	"❌": "cross mark",
+	"⚠": "warning",
+	"🚨": "police car light",
+}
+
+// Substitution is text that was replaced because the display couldn't show
+// it.
+type Substitution struct {
+	From string
+	To   string
+}
+
+// variationSelector asks for a character to be drawn as an emoji. It's
+// ignored when looking for substitutions, so they match either way.
+const variationSelector = '\uFE0F'
+
+// substituter replaces text using a set of rules, longest match first.
+type substituter struct {
+	rules  map[string]string
+	maxKey int // The length of the longest rule, in runes.
+}
+
+// newSubstituter returns a substituter using DefaultSubstitutions, changed
+// by overrides.
+func newSubstituter(overrides map[string]string) *substituter {
+	s := &substituter{rules: make(map[string]string)}
+	add := func(from, to string) {
+		from = strings.ReplaceAll(from, string(variationSelector), "")
+		if from == "" {
+			return
+		}
+		s.rules[from] = to
+		if n := utf8.RuneCountInString(from); n > s.maxKey {
+			s.maxKey = n
+		}
+	}
+	for from, to := range DefaultSubstitutions {
+		add(from, to)
+	}
+	for from, to := range overrides {
+		add(from, to)
+	}
+	return s
+}
+
+// replace applies the rules to text, leaving alone text that starts with a
+// rune keep returns true for. It also returns the substitutions made, each
+// listed once, in the order they first appear.
+func (s *substituter) replace(text string, keep func(rune) bool) (string, []Substitution) {
+	if s == nil || len(s.rules) == 0 {
+		return text, nil
+	}
+	src := []rune(strings.ReplaceAll(text, string(variationSelector), ""))
+	var b strings.Builder
+	var subs []Substitution
+	seen := make(map[Substitution]bool)
+	for i := 0; i < len(src); {
+		if unicode.IsSpace(src[i]) || (keep != nil && keep(src[i])) {
+			b.WriteRune(src[i])
+			i++
+			continue
+		}
+		n := s.maxKey
+		if n > len(src)-i {
+			n = len(src) - i
+		}
+		for ; n > 0; n-- {
+			from := string(src[i : i+n])
+			to, ok := s.rules[from]
+			if !ok || to == from {
+				continue
+			}
+			b.WriteString(to)
+			if sub := (Substitution{From: from, To: to}); !seen[sub] {
+				seen[sub] = true
+				subs = append(subs, sub)
+			}
+			break
+		}
+		if n == 0 {
+			b.WriteRune(src[i])
+			n = 1
+		}
+		i += n
+	}
+	return b.String(), subs
+}
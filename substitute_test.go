@@ -0,0 +1,48 @@
+package flapper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubstitute(t *testing.T) {
+	hasFlap := func(r rune) bool { return r == '!' || (r >= 'a' && r <= 'z') }
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		text      string
+		want      string
+		wantSubs  []Substitution
+	}{
+		{"none", nil, "hello", "hello", nil},
+		{"and", nil, "rock & roll", "rock and roll",
+			[]Substitution{{"&", "and"}}},
+		{"listed once", nil, "50% of 20%", "50 pct of 20 pct",
+			[]Substitution{{"%", " pct"}}},
+		{"hyphen", nil, "well-known", "well known",
+			[]Substitution{{"-", " "}}},
+		{"colon", nil, "at 12:30", "at 12.30",
+			[]Substitution{{":", "."}}},
+		{"together", nil, "q&a: 100%-ish", "qanda. 100 pct ish",
+			[]Substitution{{"&", "and"}, {":", "."}, {"%", " pct"}, {"-", " "}}},
+		{"shown as is", nil, "hi!", "hi!", nil},
+		{"emoji", nil, "i ❤️ you", "i red heart you",
+			[]Substitution{{"❤", "red heart"}}},
+		{"override", map[string]string{"&": "+"}, "a&b", "a+b",
+			[]Substitution{{"&", "+"}}},
+		{"default off", map[string]string{"&": "&"}, "a&b", "a&b", nil},
+		{"sequence", map[string]string{"->": "to", "-": ""}, "a->b-c", "atobc",
+			[]Substitution{{"->", "to"}, {"-", ""}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, subs := newSubstituter(tt.overrides).replace(tt.text, hasFlap)
+			if got != tt.want {
+				t.Errorf("replace(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if !reflect.DeepEqual(subs, tt.wantSubs) {
+				t.Errorf("replace(%q) substituted %q, want %q", tt.text, subs, tt.wantSubs)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package flapper
+
+import (
+	"fmt"
+
+	"github.com/trapgate/flapper/proto"
+	gproto "google.golang.org/protobuf/proto"
+)
+
+// Supervisor returns the most recent report from the controller's power
+// supervisor, or nil if there hasn't been one. Not all controllers have a
+// supervisor.
+func (d *Display) Supervisor() *proto.SupervisorState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.supervisor == nil {
+		return nil
+	}
+	return gproto.Clone(d.supervisor).(*proto.SupervisorState)
+}
+
+// OnFaultChange registers f to be called whenever the power supervisor enters
+// or leaves the FAULT state, or the reported fault changes. f is passed the
+// report that caused the change. It's called from the goroutine that reads
+// from the display, so it must not block.
+func (d *Display) OnFaultChange(f func(*proto.SupervisorState)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.faultFuncs = append(d.faultFuncs, f)
+}
+
+func (d *Display) handleSupervisorState(st *proto.SupervisorState) {
+	d.mu.Lock()
+	prev := d.supervisor
+	d.supervisor = st
+	funcs := append([]func(*proto.SupervisorState){}, d.faultFuncs...)
+	d.mu.Unlock()
+
+	// If the uptime went backwards the controller rebooted, and has lost its
+	// settings and what it was showing.
+	if prev != nil && st.GetUptimeMillis() < prev.GetUptimeMillis() {
+		fmt.Println("display controller restarted")
+		go d.restore()
+	}
+
+	if !faultChanged(prev, st) {
+		return
+	}
+	fmt.Println("supervisor:", FaultString(st))
+	for _, f := range funcs {
+		f(st)
+	}
+}
+
+// faultChanged reports whether the fault status differs between two
+// supervisor reports. The first report counts as a change only if it's a
+// fault.
+func faultChanged(prev, cur *proto.SupervisorState) bool {
+	curFault := cur.GetState() == proto.SupervisorState_FAULT
+	if prev == nil {
+		return curFault
+	}
+	prevFault := prev.GetState() == proto.SupervisorState_FAULT
+	if prevFault != curFault {
+		return true
+	}
+	return curFault && (prev.GetFaultInfo().GetType() != cur.GetFaultInfo().GetType() ||
+		prev.GetFaultInfo().GetTsMillis() != cur.GetFaultInfo().GetTsMillis())
+}
+
+// FaultString describes the fault in a supervisor report, or returns "ok" if
+// there isn't one.
+func FaultString(st *proto.SupervisorState) string {
+	if st.GetState() != proto.SupervisorState_FAULT {
+		return "ok"
+	}
+	fi := st.GetFaultInfo()
+	s := fmt.Sprintf("fault %v at %vms", fi.GetType(), fi.GetTsMillis())
+	if fi.GetMsg() != "" {
+		s += ": " + fi.GetMsg()
+	}
+	return s
+}
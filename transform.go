@@ -0,0 +1,208 @@
+package flapper
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/trapgate/flapper/translit"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Message is text being prepared for a display by a Transformer.
+type Message struct {
+	Text string
+	// Display is the display the text is for. Steps use it for the
+	// display's character set, configuration and geometry.
+	Display *Display
+	// Substituted lists the substitutions made so far, each once.
+	Substituted []Substitution
+	// Format is how the text will be laid out, or nil for the default.
+	// Steps that check whether the text fits use it.
+	Format *Format
+
+	inPipeline bool // Whether the display's own pipeline is running.
+}
+
+// Transformer is a step in preparing text for the display.
+type Transformer interface {
+	Transform(m *Message)
+}
+
+// TransformerFunc lets an ordinary function be used as a Transformer.
+type TransformerFunc func(m *Message)
+
+// Transform calls f(m).
+func (f TransformerFunc) Transform(m *Message) {
+	f(m)
+}
+
+// Pipeline is a Transformer that runs each of its steps in turn.
+type Pipeline []Transformer
+
+// Transform runs each step of the pipeline on m.
+func (p Pipeline) Transform(m *Message) {
+	for _, t := range p {
+		t.Transform(m)
+	}
+}
+
+// DefaultPipeline is what a display does to text before laying it out,
+// unless it's changed with SetPipeline.
+var DefaultPipeline = Pipeline{Substitute, Transliterate, Normalize, CaseFold}
+
+var (
+	// Substitute applies the display's substitutions, from
+	// Config.Substitutions and DefaultSubstitutions, to characters it can't
+	// show.
+	Substitute Transformer = TransformerFunc(func(m *Message) {
+		m.Display.mu.Lock()
+		subst := m.Display.subst
+		m.Display.mu.Unlock()
+		var subs []Substitution
+		m.Text, subs = subst.replace(norm.NFC.String(m.Text), m.Display.hasFlap())
+		m.addSubstitutions(subs)
+	})
+
+	// Transliterate writes characters from other scripts that the display
+	// can't show in Latin letters, using the tables in Config.Transliterate.
+	Transliterate Transformer = TransformerFunc(func(m *Message) {
+		m.Display.mu.Lock()
+		tr := m.Display.translit
+		m.Display.mu.Unlock()
+		m.Text = tr.String(norm.NFC.String(m.Text), m.Display.hasFlap())
+	})
+
+	// Normalize removes accents, leaving the plain letters.
+	Normalize Transformer = TransformerFunc(func(m *Message) {
+		t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+		m.Text, _, _ = transform.String(t, m.Text)
+	})
+
+	// CaseFold makes the text lowercase.
+	CaseFold Transformer = TransformerFunc(func(m *Message) {
+		m.Text = strings.ToLower(m.Text)
+	})
+)
+
+// TransliterateWith is like Transliterate, but uses the given tables instead
+// of the display's.
+func TransliterateWith(tr *translit.Transliterator) Transformer {
+	return TransformerFunc(func(m *Message) {
+		m.Text = tr.String(norm.NFC.String(m.Text), m.Display.hasFlap())
+	})
+}
+
+// SubstituteWith is like Substitute, but uses rules, added to
+// DefaultSubstitutions, instead of the display's substitutions.
+func SubstituteWith(rules map[string]string) Transformer {
+	subst := newSubstituter(rules)
+	return TransformerFunc(func(m *Message) {
+		var subs []Substitution
+		m.Text, subs = subst.replace(norm.NFC.String(m.Text), m.Display.hasFlap())
+		m.addSubstitutions(subs)
+	})
+}
+
+// Abbreviate replaces whole words, ignoring case, with the abbreviations in
+// words, which are keyed by lowercase word.
+func Abbreviate(words map[string]string) Transformer {
+	return TransformerFunc(func(m *Message) {
+		m.Text = wordRE.ReplaceAllStringFunc(m.Text, func(w string) string {
+			if abbr, ok := words[strings.ToLower(w)]; ok {
+				return abbr
+			}
+			return w
+		})
+	})
+}
+
+// wordRE matches a word.
+var wordRE = regexp.MustCompile(`[\pL\pN]+`)
+
+// ReplaceRegexp replaces the matches of re with repl, as
+// regexp.ReplaceAllString does.
+func ReplaceRegexp(re *regexp.Regexp, repl string) Transformer {
+	return TransformerFunc(func(m *Message) {
+		m.Text = re.ReplaceAllString(m.Text, repl)
+	})
+}
+
+// LayoutWith lays the text out for the display, as f says, leaving a line
+// for each row, padded to the width of the display. The text isn't run
+// through the display's pipeline first, so this should come last.
+func LayoutWith(f *Format) Transformer {
+	return TransformerFunc(func(m *Message) {
+		var hyph rune
+		if f != nil && f.Hyphenate {
+			hyph = m.Display.hyphenMark()
+		}
+		g := m.Display.Geometry()
+		lines, _, _ := layoutBox(m.Text, g.Columns, g.Rows, f, hyph)
+		m.Text = strings.Join(lines, "\n")
+	})
+}
+
+// addSubstitutions adds subs to those already made, leaving out any that are
+// already listed.
+func (m *Message) addSubstitutions(subs []Substitution) {
+	for _, s := range subs {
+		found := false
+		for _, have := range m.Substituted {
+			found = found || have == s
+		}
+		if !found {
+			m.Substituted = append(m.Substituted, s)
+		}
+	}
+}
+
+// SetPipeline changes the steps the display uses to prepare text before
+// laying it out. If p is nil, DefaultPipeline is used.
+func (d *Display) SetPipeline(p Transformer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pipeline = p
+}
+
+// Transform returns text as it would be prepared for the display, first by
+// t, if it isn't nil, and then by the display's pipeline.
+func (d *Display) Transform(text string, t Transformer) *Message {
+	m := &Message{Text: text, Display: d}
+	d.prepare(m, t)
+	return m
+}
+
+// prepare runs m through t, if it isn't nil, and then through the display's
+// pipeline.
+func (d *Display) prepare(m *Message, t Transformer) {
+	if t != nil {
+		t.Transform(m)
+	}
+	d.mu.Lock()
+	p := d.pipeline
+	d.mu.Unlock()
+	if p == nil {
+		p = DefaultPipeline
+	}
+	m.inPipeline = true
+	p.Transform(m)
+	m.inPipeline = false
+}
+
+// hasFlap returns a function reporting whether the display has a flap for
+// a rune, in either case.
+func (d *Display) hasFlap() func(rune) bool {
+	d.mu.Lock()
+	flaps := d.runes
+	d.mu.Unlock()
+	return func(r rune) bool {
+		_, ok := flaps[r]
+		if !ok {
+			_, ok = flaps[unicode.ToLower(r)]
+		}
+		return ok
+	}
+}
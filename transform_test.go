@@ -0,0 +1,56 @@
+package flapper
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		t        Transformer
+		want     string
+		wantSubs []Substitution
+	}{
+		{"default", "Crème Brûlée & Straße", nil, "creme brulee and strasse",
+			[]Substitution{{"&", "and"}}},
+		{"abbreviate", "North Pacific Islands, Fiji",
+			Abbreviate(map[string]string{"north": "n", "islands": "is."}),
+			"n pacific is., fiji", nil},
+		{"pipeline", "150 km NE of Ōtaki & Levin",
+			Pipeline{
+				ReplaceRegexp(regexp.MustCompile(`\d+ km [NSEW]+ of `), ""),
+				SubstituteWith(map[string]string{"&": "+"}),
+			},
+			"otaki + levin", []Substitution{{"&", "+"}}},
+		{"func", "abc",
+			TransformerFunc(func(m *Message) { m.Text += "!" }),
+			"abc.", []Substitution{{"!", "."}}},
+	}
+	d := newDisplay()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := d.Transform(tt.text, tt.t)
+			if m.Text != tt.want {
+				t.Errorf("Transform(%q) = %q, want %q", tt.text, m.Text, tt.want)
+			}
+			if !reflect.DeepEqual(m.Substituted, tt.wantSubs) {
+				t.Errorf("Transform(%q) substituted %q, want %q", tt.text, m.Substituted, tt.wantSubs)
+			}
+		})
+	}
+}
+
+func TestSetPipeline(t *testing.T) {
+	d := newDisplay()
+	d.SetPipeline(Pipeline{CaseFold})
+	if got, want := d.PrepText("Ça"), "ça          \n            "; got != want {
+		t.Errorf("PrepText with case folding only = %q, want %q", got, want)
+	}
+	d.SetPipeline(nil)
+	if got, want := d.PrepText("Ça"), "ca          \n            "; got != want {
+		t.Errorf("PrepText with the default pipeline = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+package translit
+
+import "strings"
+
+// Han gives the Mandarin reading, in pinyin without tones, of common Chinese
+// characters, in both simplified and traditional forms. Characters with more
+// than one reading get the most common one, and those that aren't listed are
+// left alone. The syllables of a word run together, as the characters do.
+var Han = register(NewTable("han", "common Chinese characters, as pinyin", hanTable()))
+
+// hanReadings lists characters and their readings, in pairs.
+const hanReadings = `
+的 de 一 yi 是 shi 不 bu 了 le 人 ren 我 wo 在 zai 有 you 他 ta 这 zhe 這 zhe
+中 zhong 大 da 来 lai 來 lai 上 shang 国 guo 國 guo 个 ge 個 ge 到 dao 说 shuo
+說 shuo 们 men 們 men 为 wei 為 wei 子 zi 和 he 你 ni 地 di 出 chu 道 dao 也 ye
+时 shi 時 shi 年 nian 得 de 就 jiu 那 na 要 yao 下 xia 以 yi 生 sheng 会 hui
+會 hui 自 zi 着 zhe 去 qu 之 zhi 过 guo 過 guo 家 jia 学 xue 學 xue 对 dui
+對 dui 可 ke 她 ta 里 li 裡 li 後 hou 后 hou 小 xiao 么 me 麼 me 心 xin 多 duo
+天 tian 而 er 能 neng 好 hao 都 dou 然 ran 没 mei 沒 mei 日 ri 于 yu 於 yu
+起 qi 还 hai 還 hai 发 fa 發 fa 成 cheng 事 shi 只 zhi 作 zuo 当 dang 當 dang
+想 xiang 看 kan 文 wen 无 wu 無 wu 开 kai 開 kai 手 shou 十 shi 用 yong
+主 zhu 行 xing 方 fang 又 you 如 ru 前 qian 所 suo 本 ben 见 jian 見 jian
+经 jing 經 jing 头 tou 頭 tou 面 mian 公 gong 同 tong 三 san 已 yi 老 lao
+从 cong 從 cong 动 dong 動 dong 两 liang 兩 liang 长 chang 長 chang 知 zhi
+民 min 样 yang 樣 yang 现 xian 現 xian 分 fen 将 jiang 將 jiang 外 wai 但 dan
+身 shen 些 xie 与 yu 與 yu 高 gao 意 yi 进 jin 進 jin 把 ba 法 fa 此 ci
+实 shi 實 shi 回 hui 二 er 理 li 美 mei 点 dian 點 dian 月 yue 明 ming 其 qi
+种 zhong 種 zhong 声 sheng 聲 sheng 全 quan 工 gong 己 ji 话 hua 話 hua
+儿 er 兒 er 者 zhe 向 xiang 情 qing 部 bu 正 zheng 名 ming 定 ding 女 nü
+问 wen 問 wen 力 li 机 ji 機 ji 给 gei 給 gei 等 deng 几 ji 幾 ji 很 hen
+业 ye 業 ye 最 zui 间 jian 間 jian 新 xin 什 shen 打 da 便 bian 位 wei 因 yin
+重 zhong 被 bei 走 zou 电 dian 電 dian 四 si 第 di 门 men 門 men 相 xiang
+次 ci 东 dong 東 dong 政 zheng 海 hai 口 kou 使 shi 教 jiao 西 xi 再 zai
+平 ping 真 zhen 听 ting 聽 ting 世 shi 气 qi 氣 qi 信 xin 北 bei 少 shao
+关 guan 關 guan 并 bing 內 nei 内 nei 加 jia 化 hua 由 you 却 que 卻 que
+代 dai 军 jun 軍 jun 产 chan 產 chan 入 ru 先 xian 山 shan 五 wu 太 tai
+水 shui 万 wan 萬 wan 市 shi 眼 yan 体 ti 體 ti 别 bie 別 bie 处 chu 處 chu
+总 zong 總 zong 才 cai 场 chang 場 chang 师 shi 師 shi 书 shu 書 shu 比 bi
+住 zhu 员 yuan 員 yuan 九 jiu 笑 xiao 性 xing 通 tong 目 mu 华 hua 華 hua
+报 bao 報 bao 立 li 马 ma 馬 ma 命 ming 张 zhang 張 zhang 活 huo 难 nan
+難 nan 神 shen 数 shu 數 shu 件 jian 安 an 表 biao 原 yuan 车 che 車 che
+白 bai 应 ying 應 ying 路 lu 期 qi 叫 jiao 死 si 常 chang 提 ti 感 gan
+金 jin 何 he 更 geng 反 fan 合 he 放 fang 做 zuo 系 xi 计 ji 計 ji 或 huo
+司 si 利 li 受 shou 光 guang 王 wang 果 guo 亲 qin 親 qin 界 jie 及 ji
+今 jin 京 jing 务 wu 務 wu 制 zhi 解 jie 各 ge 任 ren 至 zhi 清 qing 物 wu
+台 tai 臺 tai 象 xiang 记 ji 記 ji 边 bian 邊 bian 共 gong 风 feng 風 feng
+战 zhan 戰 zhan 干 gan 接 jie 它 ta 许 xu 許 xu 八 ba 特 te 觉 jue 覺 jue
+望 wang 直 zhi 服 fu 毛 mao 林 lin 题 ti 題 ti 建 jian 南 nan 度 du 统 tong
+統 tong 色 se 字 zi 请 qing 請 qing 交 jiao 爱 ai 愛 ai 让 rang 讓 rang
+认 ren 認 ren 算 suan 论 lun 論 lun 百 bai 吃 chi 义 yi 義 yi 科 ke 怎 zen
+元 yuan 社 she 术 shu 術 shu 结 jie 結 jie 六 liu 功 gong 指 zhi 思 si
+非 fei 流 liu 每 mei 青 qing 管 guan 夫 fu 连 lian 連 lian 远 yuan 遠 yuan
+资 zi 資 zi 队 dui 隊 dui 跟 gen 带 dai 帶 dai 花 hua 快 kuai 条 tiao 條 tiao
+院 yuan 变 bian 變 bian 联 lian 聯 lian 言 yan 权 quan 權 quan 往 wang
+展 zhan 该 gai 該 gai 领 ling 領 ling 传 chuan 傳 chuan 近 jin 留 liu
+红 hong 紅 hong 治 zhi 决 jue 決 jue 周 zhou 保 bao 达 da 達 da 办 ban
+辦 ban 运 yun 運 yun 武 wu 半 ban 候 hou 七 qi 必 bi 城 cheng 父 fu 强 qiang
+強 qiang 步 bu 完 wan 革 ge 深 shen 区 qu 區 qu 即 ji 求 qiu 品 pin 士 shi
+转 zhuan 轉 zhuan 量 liang 空 kong 众 zhong 眾 zhong 技 ji 轻 qing 輕 qing
+程 cheng 告 gao 江 jiang 语 yu 語 yu 英 ying 基 ji 派 pai 满 man 滿 man
+式 shi 李 li 息 xi 写 xie 寫 xie 识 shi 識 shi 极 ji 極 ji 令 ling 黄 huang
+黃 huang 德 de 收 shou 钱 qian 錢 qian 未 wei 持 chi 取 qu 设 she 設 she
+始 shi 双 shuang 雙 shuang 历 li 歷 li 越 yue 史 shi 商 shang 千 qian
+片 pian 容 rong 研 yan 像 xiang 找 zhao 友 you 站 zhan 广 guang 廣 guang
+改 gai 议 yi 議 yi 形 xing 委 wei 早 zao 房 fang 音 yin 火 huo 际 ji 際 ji
+则 ze 則 ze 首 shou 单 dan 單 dan 据 ju 據 ju 导 dao 導 dao 影 ying 失 shi
+网 wang 網 wang 香 xiang 似 si 斯 si 专 zhuan 專 zhuan 石 shi 若 ruo
+兵 bing 谁 shui 誰 shui 校 xiao 读 du 讀 du 志 zhi 飞 fei 飛 fei 观 guan
+觀 guan 争 zheng 爭 zheng 包 bao 组 zu 組 zu 造 zao 落 luo 视 shi 視 shi
+济 ji 濟 ji 离 li 離 li 集 ji 编 bian 編 bian 宝 bao 寶 bao 府 fu 拉 la
+黑 hei 且 qie 随 sui 隨 sui 格 ge 布 bu 微 wei 母 mu 局 ju 根 gen 团 tuan
+團 tuan 段 duan 终 zhong 終 zhong 乐 le 樂 le 级 ji 級 ji 克 ke 精 jing
+官 guan 示 shi 冷 leng 域 yu 省 sheng 县 xian 縣 xian 州 zhou 湾 wan 灣 wan
+岛 dao 島 dao 河 he 湖 hu 港 gang 庆 qing 慶 qing 津 jin 苏 su 蘇 su
+浙 zhe 福 fu 云 yun 雲 yun 贵 gui 貴 gui 陕 shan 陝 shan 甘 gan 肃 su
+肅 su 藏 zang 疆 jiang 蒙 meng 古 gu 宁 ning 寧 ning 夏 xia 辽 liao 遼 liao
+吉 ji 龙 long 龍 long 桂 gui 琼 qiong 瓊 qiong 湘 xiang 鄂 e 豫 yu 皖 wan
+赣 gan 贛 gan 冀 ji 晋 jin 晉 jin 鲁 lu 魯 lu 闽 min 閩 min 粤 yue 粵 yue
+渝 yu 川 chuan 沙 sha 阳 yang 陽 yang 州 zhou 庄 zhuang 莊 zhuang 昌 chang
+汉 han 漢 han 武 wu 沈 shen 哈 ha 尔 er 爾 er 滨 bin 濱 bin 昆 kun 拉 la
+萨 sa 薩 sa 乌 wu 烏 wu 鲁 lu 木 mu 齐 qi 齊 qi 兰 lan 蘭 lan 银 yin 銀 yin
+呼 hu 和 he 浩 hao 特 te 郑 zheng 鄭 zheng 合 he 肥 fei 杭 hang 厦 xia
+廈 xia 深 shen 圳 zhen 珠 zhu 澳 ao 桃 tao 园 yuan 園 yuan 高 gao 雄 xiong
+基 ji 隆 long 花 hua 莲 lian 蓮 lian 嘉 jia 义 yi 屏 ping 宜 yi 兰 lan
+震 zhen 级 ji 里 li 公 gong 米 mi 深 shen 度 du 报 bao 告 gao 警 jing
+`
+
+func hanTable() map[string]string {
+	m := make(map[string]string)
+	fields := strings.Fields(hanReadings)
+	for i := 0; i+1 < len(fields); i += 2 {
+		if _, ok := m[fields[i]]; !ok {
+			m[fields[i]] = fields[i+1]
+		}
+	}
+	return m
+}
@@ -0,0 +1,74 @@
+package translit
+
+import "strings"
+
+// Kana covers hiragana and katakana, in Hepburn romanization. Long vowels
+// are written as single vowels.
+var Kana = register(NewTable("kana", "Japanese hiragana and katakana, as Hepburn", kanaTable()))
+
+// hiragana gives the romanization of each hiragana. The katakana are at a
+// fixed offset from them.
+var hiragana = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+	'ゃ': "ya", 'ゅ': "yu", 'ょ': "yo", 'ゎ': "wa", 'っ': "",
+}
+
+// katakanaOffset is the distance from each hiragana to its katakana.
+const katakanaOffset = 'ア' - 'あ'
+
+func kanaTable() map[string]string {
+	m := make(map[string]string)
+	add := func(k, v string) {
+		m[k] = v
+		// The katakana version of k.
+		m[strings.Map(func(r rune) rune { return r + katakanaOffset }, k)] = v
+	}
+	for k, v := range hiragana {
+		add(string(k), v)
+	}
+	// Syllables ending in i combine with a small ya, yu or yo.
+	for k, v := range hiragana {
+		if len(v) < 2 || v[len(v)-1] != 'i' || k == 'い' {
+			continue
+		}
+		base := v[:len(v)-1]
+		if base != "sh" && base != "ch" && base != "j" {
+			base += "y"
+		}
+		add(string(k)+"ゃ", base+"a")
+		add(string(k)+"ゅ", base+"u")
+		add(string(k)+"ょ", base+"o")
+	}
+	// A small tsu doubles the consonant after it.
+	var syllables []string
+	for k, v := range m {
+		if []rune(k)[0] < 'ア' && v != "" && !strings.ContainsAny(v[:1], "aeiouny") {
+			syllables = append(syllables, k)
+		}
+	}
+	for _, k := range syllables {
+		v := m[k]
+		double := v[:1]
+		if strings.HasPrefix(v, "ch") {
+			double = "t"
+		}
+		add("っ"+k, double+v)
+	}
+	m["ー"] = ""
+	return m
+}
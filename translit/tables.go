@@ -0,0 +1,167 @@
+package translit
+
+// Latin covers letters of Latin alphabets that aren't a plain letter with
+// accents, and so don't become one when the accents are removed.
+var Latin = register(NewTable("latin", "Latin letters without a plain form, like ß, æ and ł", map[string]string{
+	"ß": "ss",
+	"ẞ": "ss",
+	"æ": "ae",
+	"ǽ": "ae",
+	"œ": "oe",
+	"ø": "o",
+	"ǿ": "o",
+	"ł": "l",
+	"đ": "d",
+	"ð": "d",
+	"þ": "th",
+	"ı": "i",
+	"ŋ": "ng",
+	"ħ": "h",
+	"ŧ": "t",
+	"ĸ": "k",
+	"ſ": "s",
+	"ĳ": "ij",
+}))
+
+// Cyrillic follows ISO 9. Its accented letters become plain ones on displays
+// without accents, so, for example, ж is shown as z. The hard and soft signs,
+// ʺ and ʹ in ISO 9, are dropped, since displays don't have flaps for them.
+var Cyrillic = register(NewTable("cyrillic", "Cyrillic, as ISO 9", map[string]string{
+	"а": "a",
+	"б": "b",
+	"в": "v",
+	"г": "g",
+	"ґ": "g̀",
+	"д": "d",
+	"ѓ": "ǵ",
+	"е": "e",
+	"ё": "ë",
+	"є": "ê",
+	"ж": "ž",
+	"з": "z",
+	"ѕ": "ẑ",
+	"и": "i",
+	"і": "ì",
+	"ї": "ï",
+	"й": "j",
+	"ј": "ǰ",
+	"к": "k",
+	"л": "l",
+	"љ": "l̂",
+	"м": "m",
+	"н": "n",
+	"њ": "n̂",
+	"о": "o",
+	"п": "p",
+	"р": "r",
+	"с": "s",
+	"т": "t",
+	"ќ": "ḱ",
+	"у": "u",
+	"ў": "ŭ",
+	"ф": "f",
+	"х": "h",
+	"ц": "c",
+	"ч": "č",
+	"џ": "d̂",
+	"ш": "š",
+	"щ": "ŝ",
+	"ъ": "",
+	"ы": "y",
+	"ь": "",
+	"ѣ": "ě",
+	"э": "è",
+	"ю": "û",
+	"я": "â",
+	"ѫ": "ǎ",
+	"ѳ": "f̀",
+	"ѵ": "ỳ",
+}))
+
+// Greek follows ELOT 743, leaving out the accents it keeps.
+var Greek = register(NewTable("greek", "Greek, as ELOT 743", map[string]string{
+	"α": "a",
+	"ά": "a",
+	"β": "v",
+	"γ": "g",
+	"δ": "d",
+	"ε": "e",
+	"έ": "e",
+	"ζ": "z",
+	"η": "i",
+	"ή": "i",
+	"θ": "th",
+	"ι": "i",
+	"ί": "i",
+	"ϊ": "i",
+	"ΐ": "i",
+	"κ": "k",
+	"λ": "l",
+	"μ": "m",
+	"ν": "n",
+	"ξ": "x",
+	"ο": "o",
+	"ό": "o",
+	"π": "p",
+	"ρ": "r",
+	"σ": "s",
+	"ς": "s",
+	"τ": "t",
+	"υ": "y",
+	"ύ": "y",
+	"ϋ": "y",
+	"ΰ": "y",
+	"φ": "f",
+	"χ": "ch",
+	"ψ": "ps",
+	"ω": "o",
+	"ώ": "o",
+	// Letters written differently in combination.
+	"γγ": "ng",
+	"γξ": "nx",
+	"γχ": "nch",
+	"ου": "ou",
+	"ού": "ou",
+	"αυ": "av",
+	"αύ": "av",
+	"ευ": "ev",
+	"εύ": "ev",
+	"ηυ": "iv",
+	"ηύ": "iv",
+}))
+
+// Hebrew gives the consonants only, as vowel points are rarely written.
+var Hebrew = register(NewTable("hebrew", "Hebrew consonants", map[string]string{
+	"א":  "",
+	"ב":  "v",
+	"בּ": "b",
+	"ג":  "g",
+	"ד":  "d",
+	"ה":  "h",
+	"ו":  "v",
+	"ז":  "z",
+	"ח":  "ch",
+	"ט":  "t",
+	"י":  "y",
+	"כ":  "kh",
+	"כּ": "k",
+	"ך":  "kh",
+	"ל":  "l",
+	"מ":  "m",
+	"ם":  "m",
+	"נ":  "n",
+	"ן":  "n",
+	"ס":  "s",
+	"ע":  "",
+	"פ":  "f",
+	"פּ": "p",
+	"ף":  "f",
+	"צ":  "ts",
+	"ץ":  "ts",
+	"ק":  "k",
+	"ר":  "r",
+	"ש":  "sh",
+	"שׁ": "sh",
+	"שׂ": "s",
+	"ת":  "t",
+}))
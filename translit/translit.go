@@ -0,0 +1,162 @@
+// Package translit writes text from other scripts in Latin letters, so it can
+// be shown on displays that only have flaps for those. Each script has its
+// own table, and a Transliterator applies a set of them.
+package translit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Table maps lowercase text in one script to Latin letters. Keys can be more
+// than one rune long, for letters written differently in combination, and
+// the longest key that matches is used.
+type Table struct {
+	Name        string
+	Description string
+	m           map[string]string
+	maxKey      int // The length of the longest key, in runes.
+}
+
+// NewTable returns a Table using m. Keys should be lowercase.
+func NewTable(name, description string, m map[string]string) *Table {
+	t := &Table{Name: name, Description: description, m: m}
+	for k := range m {
+		if n := utf8.RuneCountInString(k); n > t.maxKey {
+			t.maxKey = n
+		}
+	}
+	return t
+}
+
+var tables = map[string]*Table{}
+
+// register adds a built-in table.
+func register(t *Table) *Table {
+	tables[t.Name] = t
+	return t
+}
+
+// Tables returns the names of the built-in tables, sorted.
+func Tables() []string {
+	var names []string
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the built-in table with the given name.
+func Lookup(name string) (*Table, bool) {
+	t, ok := tables[name]
+	return t, ok
+}
+
+// Transliterator applies a set of tables to text.
+type Transliterator struct {
+	tables []*Table
+	maxKey int
+}
+
+// New returns a Transliterator using the named built-in tables, or all of
+// them if no names are given.
+func New(names ...string) (*Transliterator, error) {
+	if len(names) == 0 {
+		names = Tables()
+	}
+	var ts []*Table
+	for _, name := range names {
+		t, ok := Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown transliteration table %q; have %s",
+				name, strings.Join(Tables(), ", "))
+		}
+		ts = append(ts, t)
+	}
+	return NewWithTables(ts...), nil
+}
+
+// NewWithTables returns a Transliterator using the given tables. Where more
+// than one has a key, the first table's entry is used.
+func NewWithTables(ts ...*Table) *Transliterator {
+	t := &Transliterator{tables: ts}
+	for _, tt := range ts {
+		if tt.maxKey > t.maxKey {
+			t.maxKey = tt.maxKey
+		}
+	}
+	return t
+}
+
+// lookup returns the transliteration of key.
+func (t *Transliterator) lookup(key string) (string, bool) {
+	for _, tt := range t.tables {
+		if v, ok := tt.m[key]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// String transliterates s. Text the tables don't cover is left as it is, as
+// are runes that keep returns true for, so characters the display can show
+// aren't replaced; keep may be nil. Where the text being replaced starts
+// with a capital, so does its replacement.
+func (t *Transliterator) String(s string, keep func(rune) bool) string {
+	if t == nil || len(t.tables) == 0 {
+		return s
+	}
+	src := []rune(s)
+	lower := []rune(strings.ToLower(s))
+	if len(lower) != len(src) {
+		// Lowercasing changed the number of runes, which is rare enough to
+		// handle a rune at a time.
+		lower = make([]rune, len(src))
+		for i, r := range src {
+			lower[i] = unicode.ToLower(r)
+		}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(src); {
+		if keep != nil && keep(src[i]) {
+			b.WriteRune(src[i])
+			i++
+			continue
+		}
+		n := t.maxKey
+		if n > len(src)-i {
+			n = len(src) - i
+		}
+		for ; n > 0; n-- {
+			v, ok := t.lookup(string(lower[i : i+n]))
+			if !ok {
+				continue
+			}
+			if unicode.IsUpper(src[i]) {
+				v = capitalize(v)
+			}
+			b.WriteString(v)
+			break
+		}
+		if n == 0 {
+			b.WriteRune(src[i])
+			n = 1
+		}
+		i += n
+	}
+	return b.String()
+}
+
+// capitalize makes the first letter of s uppercase.
+func capitalize(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[n:]
+}
@@ -0,0 +1,55 @@
+package translit
+
+import "testing"
+
+func TestString(t *testing.T) {
+	all, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in   string
+		keep func(rune) bool
+		want string
+	}{
+		{"hello", nil, "hello"},
+		{"Straße", nil, "Strasse"},
+		{"Łódź", nil, "Lódź"},
+		{"Øresund", nil, "Oresund"},
+		{"Москва", nil, "Moskva"},
+		{"Жуковский", nil, "Žukovskij"},
+		{"Подъезд", nil, "Podezd"},
+		{"Αθήνα", nil, "Athina"},
+		{"Κέρκυρα", nil, "Kerkyra"},
+		{"αυτοκίνητο", nil, "avtokinito"},
+		{"Άγγελος", nil, "Angelos"},
+		{"とうきょう", nil, "toukyou"},
+		{"ちゃ", nil, "cha"},
+		{"きって", nil, "kitte"},
+		{"マッチ", nil, "matchi"},
+		{"コーヒー", nil, "kohi"},
+		{"北京", nil, "beijing"},
+		{"臺灣", nil, "taiwan"},
+		{"שלום", nil, "shlvm"},
+		{"a→b", nil, "a→b"},
+		{"Αθήνα", func(r rune) bool { return r == 'θ' }, "Aθina"},
+	}
+	for _, tt := range tests {
+		if got := all.String(tt.in, tt.keep); got != tt.want {
+			t.Errorf("String(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	tr, err := New("greek")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tr.String("Москва αβ", nil), "Москва av"; got != want {
+		t.Errorf("greek only: got %q, want %q", got, want)
+	}
+	if _, err := New("klingon"); err == nil {
+		t.Error("New accepted an unknown table")
+	}
+}
@@ -0,0 +1,26 @@
+package flapper
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNoLineControl is returned by HardReset when the display's transport
+// can't drive the modem control lines.
+var ErrNoLineControl = errors.New("transport does not support line control")
+
+// Transport is the byte stream used to talk to the splitflap controller.
+// Normally this is the USB serial port, but anything that can carry the framed
+// protobuf messages will do, including the in-memory controller in the
+// flappertest package.
+type Transport interface {
+	io.ReadWriteCloser
+}
+
+// LineController is implemented by transports that can drive the RTS and DTR
+// lines. It's used by HardReset to reboot the microcontroller; serial.Port
+// satisfies it.
+type LineController interface {
+	SetRTS(rts bool) error
+	SetDTR(dtr bool) error
+}